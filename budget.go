@@ -0,0 +1,199 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/decred/dcrutil"
+)
+
+var (
+	// budgetNoneStr is the string indicating that the whole spendable
+	// balance is available to buy tickets with in each window.
+	budgetNoneStr = "none"
+
+	// budgetPercentStr is the string indicating that a percentage of the
+	// spendable balance is available to buy tickets with in each window.
+	budgetPercentStr = "percent"
+
+	// budgetFixedStr is the string indicating that a fixed amount is
+	// available to buy tickets with in each window.
+	budgetFixedStr = "fixed"
+
+	// budgetDCAStr is the string indicating that a total amount is spread
+	// over a number of windows, dollar-cost averaging into tickets.
+	budgetDCAStr = "dca"
+)
+
+// dcaState is the progress through the dollar-cost averaging budget. It
+// is stored in the history so that the budget resumes where it left off
+// when the ticket buyer is restarted. Amounts are in atoms.
+type dcaState struct {
+	BudgetAmount  float64 `json:"budgetamount"`
+	BudgetWindows int     `json:"budgetwindows"`
+	Remaining     int64   `json:"remaining"`
+	WindowsLeft   int     `json:"windowsleft"`
+	Window        int     `json:"window"`
+	WindowBudget  int64   `json:"windowbudget"`
+	WindowSpent   int64   `json:"windowspent"`
+}
+
+// queueWindow returns the window period whose tickets are queued at
+// height. The queue is filled at the last block of the previous window.
+func queueWindow(height int32) int {
+	return int((height + 1) / int32(activeNet.StakeDiffWindowSize))
+}
+
+// loadBudget starts the dollar-cost averaging budget, resuming the state
+// stored in the history if it was stored for the same budgetamount and
+// budgetwindows.
+func (t *ticketPurchaser) loadBudget() error {
+	if t.cfg.BudgetMode != budgetDCAStr {
+		return nil
+	}
+	amt, err := dcrutil.NewAmount(t.cfg.BudgetAmount)
+	if err != nil {
+		return err
+	}
+	t.dca = dcaState{
+		BudgetAmount:  t.cfg.BudgetAmount,
+		BudgetWindows: t.cfg.BudgetWindows,
+		Remaining:     int64(amt),
+		WindowsLeft:   t.cfg.BudgetWindows,
+		Window:        -1,
+	}
+	if t.history == nil {
+		return nil
+	}
+
+	var stored dcaState
+	found, err := t.history.state(dcaStateKey, &stored)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+	if stored.BudgetAmount != t.cfg.BudgetAmount ||
+		stored.BudgetWindows != t.cfg.BudgetWindows {
+		log.Infof("Starting a new dollar-cost averaging budget because "+
+			"budgetamount or budgetwindows changed from %v over %v windows",
+			stored.BudgetAmount, stored.BudgetWindows)
+		return nil
+	}
+	t.dca = stored
+	log.Infof("Resuming the dollar-cost averaging budget with %v left to "+
+		"spend over %v more %s", dcrutil.Amount(stored.Remaining),
+		stored.WindowsLeft, pickNoun(stored.WindowsLeft, "window",
+			"windows"))
+	return nil
+}
+
+// saveBudget stores the state of the dollar-cost averaging budget in the
+// history.
+func (t *ticketPurchaser) saveBudget() {
+	if t.history == nil {
+		return
+	}
+	if err := t.history.putState(dcaStateKey, &t.dca); err != nil {
+		log.Errorf("Failed to store the dollar-cost averaging budget in "+
+			"the history: %v", err)
+	}
+}
+
+// windowBudget returns the amount available to buy the tickets queued at
+// height with and a description of how it was found. The budget never
+// exceeds the spendable balance. With dollar-cost averaging, the part of
+// the total that was not spent in the previous windows is spread over the
+// remaining windows, and nothing is available once they have all passed.
+// If the queue of the window was already filled, which happens when the
+// ticket buyer is restarted, what is left of the window's budget is
+// available instead. The window is only counted against the dollar-cost
+// averaging budget by budgetWindowStarted, so the pre-split outputs may be
+// sized from the budget before the purchase round starts the window.
+func (t *ticketPurchaser) windowBudget(height int32,
+	balSpendable dcrutil.Amount) (dcrutil.Amount, string, error) {
+	var budget dcrutil.Amount
+	var desc string
+	switch t.cfg.BudgetMode {
+	case budgetPercentStr:
+		budget = dcrutil.Amount(float64(balSpendable) *
+			t.cfg.BudgetPercent / 100.0)
+		desc = fmt.Sprintf("%v%% of the spendable balance %v",
+			t.cfg.BudgetPercent, balSpendable)
+	case budgetFixedStr:
+		amt, err := dcrutil.NewAmount(t.cfg.BudgetAmount)
+		if err != nil {
+			return 0, "", err
+		}
+		budget = amt
+		desc = fmt.Sprintf("the fixed budget of %v per window", amt)
+	case budgetDCAStr:
+		if queueWindow(height) == t.dca.Window {
+			budget = dcrutil.Amount(t.dca.WindowBudget - t.dca.WindowSpent)
+			if budget < 0 {
+				budget = 0
+			}
+			desc = fmt.Sprintf("%v left of this window's dollar-cost "+
+				"averaging budget of %v", budget,
+				dcrutil.Amount(t.dca.WindowBudget))
+			break
+		}
+		if t.dca.WindowsLeft <= 0 {
+			return 0, fmt.Sprintf("nothing, all %v windows of the "+
+				"dollar-cost averaging budget have passed",
+				t.cfg.BudgetWindows), nil
+		}
+		remaining := dcrutil.Amount(t.dca.Remaining)
+		budget = remaining / dcrutil.Amount(t.dca.WindowsLeft)
+		desc = fmt.Sprintf("%v left to spend of the dollar-cost "+
+			"averaging budget over %v more %s", remaining,
+			t.dca.WindowsLeft, pickNoun(t.dca.WindowsLeft, "window",
+				"windows"))
+	default:
+		return balSpendable, fmt.Sprintf("the spendable balance %v",
+			balSpendable), nil
+	}
+
+	if budget > balSpendable {
+		budget = balSpendable
+		desc += fmt.Sprintf(", limited to the spendable balance %v",
+			balSpendable)
+	}
+	return budget, desc, nil
+}
+
+// budgetWindowStarted counts the window whose queue was filled at height
+// against the windows of the dollar-cost averaging budget, unless it was
+// already counted before a restart.
+func (t *ticketPurchaser) budgetWindowStarted(height int32,
+	budget dcrutil.Amount) {
+	window := queueWindow(height)
+	if t.cfg.BudgetMode != budgetDCAStr || window == t.dca.Window ||
+		t.dca.WindowsLeft <= 0 {
+		return
+	}
+	t.dca.WindowsLeft--
+	t.dca.Window = window
+	t.dca.WindowBudget = int64(budget)
+	t.dca.WindowSpent = 0
+	t.saveBudget()
+}
+
+// budgetSpent records the cost of tickets purchased, from the window
+// queue or manually, against the dollar-cost averaging budget.
+func (t *ticketPurchaser) budgetSpent(numTickets int, price dcrutil.Amount) {
+	if t.cfg.BudgetMode != budgetDCAStr || numTickets == 0 {
+		return
+	}
+	cost := int64(dcrutil.Amount(numTickets) * price)
+	t.dca.Remaining -= cost
+	if t.dca.Remaining < 0 {
+		t.dca.Remaining = 0
+	}
+	t.dca.WindowSpent += cost
+	t.saveBudget()
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/decred/dcrutil"
+)
+
+// newDCAPurchaser returns a purchaser with a dollar-cost averaging budget
+// of amount coins over windows windows, stored in h if it is not nil.
+func newDCAPurchaser(t *testing.T, amount float64, windows int,
+	h *history) *ticketPurchaser {
+	cfg := defaultConfig()
+	cfg.BudgetMode = budgetDCAStr
+	cfg.BudgetAmount = amount
+	cfg.BudgetWindows = windows
+	p := &ticketPurchaser{cfg: &cfg, history: h}
+	if err := p.loadBudget(); err != nil {
+		t.Fatalf("failed to load the budget: %v", err)
+	}
+	return p
+}
+
+// TestDCABudget ensures that the dollar-cost averaging budget spreads what
+// was not spent in the previous windows over the remaining windows, that a
+// window refilled after a restart only gets what is left of its budget
+// and that manual purchases count against it.
+func TestDCABudget(t *testing.T) {
+	winSize := int32(activeNet.StakeDiffWindowSize)
+	const price = dcrutil.Amount(10e8)
+	const balance = dcrutil.Amount(1000e8)
+
+	// Each step fills the queue at height and then buys tickets at 10
+	// coins.
+	tests := []struct {
+		name    string
+		height  int32
+		balance dcrutil.Amount
+		bought  int
+		want    dcrutil.Amount
+	}{
+		{"first window", winSize - 1, balance, 2, 25e8},
+		{"restart in the first window", winSize + 10, balance, 0, 5e8},
+		{"unspent budget carried over", 2*winSize - 1, balance, 0,
+			dcrutil.Amount(80e8) / 3},
+		{"carried over again", 3*winSize - 1, balance, 4, 40e8},
+		{"limited by the balance", 4*winSize - 1, 30e8, 0, 30e8},
+		{"all windows passed", 5*winSize - 1, balance, 0, 0},
+	}
+
+	p := newDCAPurchaser(t, 100, 4, nil)
+	for _, test := range tests {
+		got, _, err := p.windowBudget(test.height, test.balance)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got budget %v, want %v", test.name, got,
+				test.want)
+		}
+		p.budgetWindowStarted(test.height, got)
+		p.budgetSpent(test.bought, price)
+	}
+
+	// Manual purchases beyond the budget leave nothing to spend.
+	p = newDCAPurchaser(t, 100, 2, nil)
+	p.budgetSpent(11, price)
+	got, _, err := p.windowBudget(winSize-1, balance)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != 0 {
+		t.Errorf("got budget %v after overspending, want 0", got)
+	}
+}
+
+// TestDCABudgetResume ensures the dollar-cost averaging budget resumes
+// from the history after a restart, unless the budget settings changed.
+func TestDCABudgetResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "budget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, defaultHistoryFilename)
+	winSize := int32(activeNet.StakeDiffWindowSize)
+
+	h, err := openHistory(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newDCAPurchaser(t, 100, 4, h)
+	budget, _, err := p.windowBudget(winSize-1, 1000e8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.budgetWindowStarted(winSize-1, budget)
+	p.budgetSpent(1, 10e8)
+	want := p.dca
+	h.close()
+
+	h, err = openHistory(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.close()
+	p = newDCAPurchaser(t, 100, 4, h)
+	if p.dca != want {
+		t.Errorf("got resumed state %+v, want %+v", p.dca, want)
+	}
+	p = newDCAPurchaser(t, 200, 4, h)
+	if p.dca.Remaining != 200e8 || p.dca.WindowsLeft != 4 {
+		t.Errorf("got state %+v after changing budgetamount, want a new "+
+			"budget", p.dca)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"math"
 	"time"
 
+	"github.com/decred/dcrd/chaincfg/chainhash"
 	"github.com/decred/dcrrpcclient"
 	"github.com/decred/dcrutil"
 )
@@ -38,20 +39,27 @@ var (
 )
 
 // purchaseManager is the main handler of websocket notifications to
-// pass to the purchaser and internal quit notifications.
+// pass to the purchaser, manual purchase requests and internal quit
+// notifications.
 type purchaseManager struct {
 	purchaser          *ticketPurchaser
 	blockConnectedChan chan int32
+	manualPurchaseChan chan *manualPurchase
+	dashboard          *dashboard
 	quit               chan struct{}
 }
 
 // newPurchaseManager creates a new purchaseManager.
 func newPurchaseManager(purchaser *ticketPurchaser,
 	blockConnChan chan int32,
+	manualPurchaseChan chan *manualPurchase,
+	dashboard *dashboard,
 	quit chan struct{}) *purchaseManager {
 	return &purchaseManager{
 		purchaser:          purchaser,
 		blockConnectedChan: blockConnChan,
+		manualPurchaseChan: manualPurchaseChan,
+		dashboard:          dashboard,
 		quit:               quit,
 	}
 }
@@ -59,16 +67,84 @@ func newPurchaseManager(purchaser *ticketPurchaser,
 // blockConnectedHandler handles block connected notifications, which trigger
 // ticket purchases.
 func (p *purchaseManager) blockConnectedHandler() {
+	// Notify the systemd watchdog from this loop so that the service is
+	// restarted if a purchase round hangs.
+	var watchdog <-chan time.Time
+	if interval := sdWatchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+
 out:
 	for {
 		select {
 		case height := <-p.blockConnectedChan:
 			daemonLog.Infof("Block height %v connected", height)
-			err := p.purchaser.purchase(height)
+			err := p.purchaser.recordBlock(height)
+			if err != nil {
+				log.Errorf("Failed to record block %v in the history: %v",
+					height, err)
+			}
+			err = p.purchaser.checkExpired(height)
+			if err != nil {
+				log.Errorf("Failed to check for expired tickets: %v", err)
+			}
+			err = p.purchaser.preSplit(height)
+			if err != nil {
+				log.Errorf("Failed to pre-split outputs this round: %v",
+					err)
+			}
+			result, err := p.purchaser.purchase(height)
 			if err != nil {
-				log.Errorf("Failed to purchase tickets this round: %s",
-					err.Error())
+				log.Errorf("Failed to purchase tickets this round: %v",
+					err)
+			} else {
+				log.Debugf("Purchase round at height %v: %v", height,
+					result)
+				logJSONEvent("TKBY", "decision", result)
+				sdStatus("Height %v: %v", height, result)
+				if p.purchaser.history != nil {
+					err := p.purchaser.history.putRound(result)
+					if err != nil {
+						log.Errorf("Failed to record the purchase round "+
+							"in the history: %v", err)
+					}
+				}
+			}
+			if p.dashboard != nil {
+				p.dashboard.roundFinished(height, result, err)
+				p.dashboard.render()
 			}
+			err = p.purchaser.processSplitTickets(height)
+			if err != nil {
+				log.Errorf("Failed to process split tickets this round: %v",
+					err)
+			}
+			err = p.purchaser.consolidate(height)
+			if err != nil {
+				log.Errorf("Failed to consolidate outputs this round: %v",
+					err)
+			}
+		case mp := <-p.manualPurchaseChan:
+			log.Infof("Manual purchase of %v %s requested",
+				mp.request.Count, pickNoun(mp.request.Count, "ticket",
+					"tickets"))
+			resp := &controlPurchaseResponse{}
+			tickets, err := p.purchaser.manualPurchase(mp.request.Count,
+				mp.request.MaxPrice)
+			if err != nil {
+				log.Errorf("Failed to manually purchase tickets: %v", err)
+				resp.Error = err.Error()
+			}
+			resp.Tickets = tickets
+			mp.reply <- resp
+			if p.dashboard != nil {
+				p.dashboard.manualPurchaseFinished(tickets)
+				p.dashboard.render()
+			}
+		case <-watchdog:
+			sdNotify("WATCHDOG=1")
 		// TODO Poll every couple minute to check if connected;
 		// if not, try to reconnect.
 		case <-p.quit:
@@ -93,19 +169,35 @@ type ticketPurchaser struct {
 	ticketAddress       dcrutil.Address
 	poolAddress         dcrutil.Address
 	firstStart          bool
-	windowPeriod        int  // The current window period
-	idxDiffPeriod       int  // Relative block index within the difficulty period
-	toBuyDiffPeriod     int  // Number to buy in this period
-	purchasedDiffPeriod int  // Number already bought in this period
-	maintainMaxPrice    bool // Flag for maximum price manipulation
-	maintainMinPrice    bool // Flag for minimum price manipulation
-	useMedian           bool // Flag for using median for ticket fees
+	windowPeriod        int            // The current window period
+	idxDiffPeriod       int            // Relative block index within the difficulty period
+	toBuyDiffPeriod     int            // Number to buy in this period
+	purchasedDiffPeriod int            // Number already bought in this period
+	maintainMaxPrice    bool           // Flag for maximum price manipulation
+	maintainMinPrice    bool           // Flag for minimum price manipulation
+	useMedian           bool           // Flag for using median for ticket fees
+	useLocalStakeDiff   bool           // Flag for using the local stake diff forecaster
+	ticketFee           dcrutil.Amount // Last ticket fee per KB set in the wallet
+	lastResult          *purchaseResult
+	forecaster          *stakeDiffForecaster
+	splitCoordinator    *splitCoordinator
+	splitParticipant    *splitParticipant
+	walletPass          []byte // Wallet passphrase for autounlock, or nil
+	consolidatedWindow  int    // The last window period outputs were consolidated in
+	history             *history
+	priceLadder         []priceBand              // Price bands of the ladder strategy, or nil
+	dca                 dcaState                 // Progress through the dollar-cost averaging budget
+	queueExplain        []string                 // Reasoning behind the current window queue
+	targetPrice         float64                  // Target price of the current window queue
+	targetSource        string                   // Setting or model the target price is from
+	pendingExpiry       map[chainhash.Hash]int32 // Expiry of purchased tickets not yet mined
 }
 
 // newTicketPurchaser creates a new ticketPurchaser.
 func newTicketPurchaser(cfg *config,
 	dcrdChainSvr *dcrrpcclient.Client,
-	dcrwChainSvr *dcrrpcclient.Client) (*ticketPurchaser, error) {
+	dcrwChainSvr *dcrrpcclient.Client,
+	walletPass []byte) (*ticketPurchaser, error) {
 	var ticketAddress dcrutil.Address
 	var err error
 	if cfg.TicketAddress != "" {
@@ -133,22 +225,52 @@ func newTicketPurchaser(cfg *config,
 		maintainMinPrice = true
 	}
 
-	return &ticketPurchaser{
-		cfg:              cfg,
-		dcrdChainSvr:     dcrdChainSvr,
-		dcrwChainSvr:     dcrwChainSvr,
-		firstStart:       true,
-		ticketAddress:    ticketAddress,
-		poolAddress:      poolAddress,
-		maintainMaxPrice: maintainMaxPrice,
-		maintainMinPrice: maintainMinPrice,
-		useMedian:        cfg.FeeSource == useMedianStr,
-	}, nil
+	t := &ticketPurchaser{
+		cfg:                cfg,
+		dcrdChainSvr:       dcrdChainSvr,
+		dcrwChainSvr:       dcrwChainSvr,
+		firstStart:         true,
+		ticketAddress:      ticketAddress,
+		poolAddress:        poolAddress,
+		maintainMaxPrice:   maintainMaxPrice,
+		maintainMinPrice:   maintainMinPrice,
+		useMedian:          cfg.FeeSource == useMedianStr,
+		useLocalStakeDiff:  cfg.StakeDiffSource == useLocalStakeDiffStr,
+		forecaster:         newStakeDiffForecaster(dcrdChainSvr),
+		walletPass:         walletPass,
+		consolidatedWindow: -1,
+		pendingExpiry:      make(map[chainhash.Hash]int32),
+	}
+	if !cfg.NoHistory {
+		t.history, err = openHistory(historyFile(cfg), false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := t.loadBudget(); err != nil {
+		return nil, err
+	}
+	if cfg.QueueStrategy == queueLadderStr {
+		t.priceLadder, err = parsePriceLadder(cfg.PriceLadder)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch cfg.SplitMode {
+	case splitCoordinatorStr:
+		t.splitCoordinator = newSplitCoordinator(t, cfg.SplitListen)
+	case splitParticipantStr:
+		t.splitParticipant = newSplitParticipant(t, cfg.SplitCoordinator)
+	}
+
+	return t, nil
 }
 
-// purchase is the main handler for purchasing tickets for the user.
+// purchase is the main handler for purchasing tickets for the user. The
+// decision made is returned for every round that does not fail.
 // TODO Not make this an inlined pile of crap.
-func (t *ticketPurchaser) purchase(height int32) error {
+func (t *ticketPurchaser) purchase(height int32) (*purchaseResult, error) {
 	// Just starting up, initialize our purchaser and start
 	// buying. Set the start up regular transaction fee here
 	// too.
@@ -214,93 +336,106 @@ func (t *ticketPurchaser) purchase(height int32) error {
 		fillTicketQueue = true
 	}
 
+	result := &purchaseResult{
+		Height:       height,
+		WindowPeriod: t.windowPeriod,
+		WindowIdx:    t.idxDiffPeriod,
+	}
+
 	// Parse the ticket purchase frequency. Positive numbers mean
 	// that many tickets per block. Negative numbers mean to only
 	// purchase one ticket once every abs(num) blocks.
 	maxPerBlock := 0
 	switch {
 	case t.cfg.MaxPerBlock == 0:
-		return nil
+		result.explainf("Purchasing is disabled because maxperblock is 0")
+		return t.finishRound(result, reasonPurchasingDisabled), nil
 	case t.cfg.MaxPerBlock > 1:
 		maxPerBlock = t.cfg.MaxPerBlock
 	case t.cfg.MaxPerBlock < 0:
 		if int(height)%t.cfg.MaxPerBlock != 0 {
-			return nil
+			result.explainf("Skipping block %v because tickets are only "+
+				"purchased once every %v blocks", height, -t.cfg.MaxPerBlock)
+			return t.finishRound(result, reasonFrequencySkip), nil
 		}
 		maxPerBlock = 1
 	}
+	result.explainf("Buying at most %v %s per block (maxperblock %v)",
+		maxPerBlock, pickNoun(maxPerBlock, "ticket", "tickets"),
+		t.cfg.MaxPerBlock)
 
 	// Make sure that our wallet is connected to the daemon and the
 	// wallet is unlocked, otherwise abort.
 	walletInfo, err := t.dcrwChainSvr.WalletInfo()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !walletInfo.DaemonConnected {
-		return fmt.Errorf("Wallet not connected to daemon")
+		return nil, purchaseErrorf(ErrWalletNotConnected,
+			"Wallet not connected to daemon")
 	}
-	if !walletInfo.Unlocked {
-		return fmt.Errorf("Wallet not unlocked to allow ticket purchases")
+	if !walletInfo.Unlocked && t.walletPass == nil {
+		return nil, purchaseErrorf(ErrWalletLocked,
+			"Wallet not unlocked to allow ticket purchases")
 	}
 
 	// Pull and store relevant data about the blockchain. Calculate a
-	// "reasonable" ticket price by using the VWAP for the last 10 days
-	// (mainnet) combined with the average price of all tickets in the
-	// ticket pool. Scale this according to the configuration parameters
-	// to find minimum and maximum prices for users that are electing to
-	// attempting to manipulate the stake difficulty.
-	// First get the average price of a ticket in
-	// the ticket pool. Then get the VWAP price aside
-	// from the pool price. Calculate an average
-	// price by finding the mean.
-	poolValue, err := t.dcrdChainSvr.GetTicketPoolValue()
-	if err != nil {
-		return err
-	}
-	bestBlockH, err := t.dcrdChainSvr.GetBestBlockHash()
-	if err != nil {
-		return err
-	}
-	bestBlock, err := t.dcrdChainSvr.GetBlock(bestBlockH)
-	if err != nil {
-		return err
-	}
-	poolSize := bestBlock.MsgBlock().Header.PoolSize
-
-	// Do not allow zero pool sizes to prevent a possible
-	// panic below.
-	if poolSize == 0 {
-		poolSize += 1
-	}
-
-	avgPricePoolAmt := poolValue / dcrutil.Amount(poolSize)
-	ticketVWAP, err := t.dcrdChainSvr.TicketVWAP(nil, nil)
+	// "reasonable" ticket price using the configured average price
+	// model, by default the VWAP for the last 10 days (mainnet) combined
+	// with the average price of all tickets in the ticket pool. Scale
+	// this according to the configuration parameters to find minimum and
+	// maximum prices for users that are electing to attempting to
+	// manipulate the stake difficulty.
+	avgPriceAmt, err := t.calcAverageTicketPrice(height)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	avgPriceAmt := (ticketVWAP + avgPricePoolAmt) / 2
 	avgPrice := avgPriceAmt.ToCoin()
-	log.Tracef("Calculated average ticket price: %v", avgPriceAmt)
+	result.AvgPrice = avgPrice
+	result.explainf("Average ticket price from the %v model: %v",
+		t.cfg.AvgPriceMode, avgPriceAmt)
+	log.Debugf("Calculated average ticket price using the %v model: %v",
+		t.cfg.AvgPriceMode, avgPriceAmt)
 
 	stakeDiffs, err := t.dcrwChainSvr.GetStakeDifficulty()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	nextStakeDiff, err := dcrutil.NewAmount(stakeDiffs.NextStakeDifficulty)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	sDiffEsts, err := t.dcrdChainSvr.EstimateStakeDiff(nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	estimateSource := "dcrd"
+	if t.useLocalStakeDiff {
+		forecast, err := t.forecaster.forecast(height, nextStakeDiff)
+		if err != nil {
+			log.Warnf("Failed to forecast the stake difficulty locally, "+
+				"using the daemon estimate instead: %v", err)
+		} else {
+			log.Debugf("Local stake difficulty forecast (min %v, expected "+
+				"%v, max %v) versus daemon estimate (min %v, expected %v, "+
+				"max %v)", forecast.Min, forecast.Expected, forecast.Max,
+				sDiffEsts.Min, sDiffEsts.Expected, sDiffEsts.Max)
+			sDiffEsts = forecast
+			estimateSource = "the local forecaster"
+		}
 	}
+	result.NextStakeDiff = nextStakeDiff.ToCoin()
+	result.EstimateExpected = sDiffEsts.Expected
+	result.explainf("Next stake difficulty %v; next window estimate from "+
+		"%v: min %v, expected %v, max %v", nextStakeDiff, estimateSource,
+		sDiffEsts.Min, sDiffEsts.Expected, sDiffEsts.Max)
 	maxPriceAbsAmt, err := dcrutil.NewAmount(t.cfg.MaxPriceAbsolute)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	maxPriceScaledAmt, err := dcrutil.NewAmount(t.cfg.MaxPriceScale * avgPrice)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if t.maintainMaxPrice {
 		log.Tracef("The maximum price to maintain for this round is set to %v",
@@ -308,35 +443,77 @@ func (t *ticketPurchaser) purchase(height int32) error {
 	}
 	minPriceScaledAmt, err := dcrutil.NewAmount(t.cfg.MinPriceScale * avgPrice)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if t.maintainMinPrice {
 		log.Tracef("The minimum price to maintain for this round is set to %v",
 			minPriceScaledAmt)
 	}
+	result.MaxPriceScaled = maxPriceScaledAmt.ToCoin()
+	result.MinPriceScaled = minPriceScaledAmt.ToCoin()
+	if t.maintainMaxPrice {
+		result.explainf("Scaled maximum price: %v x %v = %v",
+			t.cfg.MaxPriceScale, avgPrice, maxPriceScaledAmt)
+	} else {
+		result.explainf("Scaled maximum price disabled (maxpricescale 0)")
+	}
+	if t.maintainMinPrice {
+		result.explainf("Scaled minimum price: %v x %v = %v",
+			t.cfg.MinPriceScale, avgPrice, minPriceScaledAmt)
+	} else {
+		result.explainf("Scaled minimum price disabled (minpricescale 0)")
+	}
 
 	balSpendable, err := t.dcrwChainSvr.GetBalanceMinConfType(t.cfg.AccountName,
 		0, "spendable")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	log.Debugf("Current spendable balance at height %v for account '%s': %v",
 		height, t.cfg.AccountName, balSpendable)
+	result.Balance = balSpendable.ToCoin()
+	result.explainf("Spendable balance of account '%s': %v",
+		t.cfg.AccountName, balSpendable)
 
 	// This is the main portion that handles filling up the
 	// queue of tickets to purchase (t.toBuyDiffPeriod).
 	if fillTicketQueue {
 		// Calculate how many tickets we could possibly buy
-		// at this difficulty.
+		// at this difficulty with the budget for the window,
+		// including what may be transferred from the funding
+		// account.
 		curPrice := nextStakeDiff
-		couldBuy := math.Floor(balSpendable.ToCoin() / nextStakeDiff.ToCoin())
+		fundable, err := t.fundingAvailable()
+		if err != nil {
+			return nil, err
+		}
+		budget, budgetDesc, err := t.windowBudget(height,
+			balSpendable+fundable)
+		if err != nil {
+			return nil, err
+		}
+		t.budgetWindowStarted(height, budget)
+		couldBuy := math.Floor(budget.ToCoin() / nextStakeDiff.ToCoin())
+		var queueExplain []string
+		explainQueue := func(format string, a ...interface{}) {
+			queueExplain = append(queueExplain, fmt.Sprintf(format, a...))
+		}
+		if fundable > 0 {
+			explainQueue("Up to %v may be transferred from account '%s'",
+				fundable, t.cfg.FundingAccount)
+		}
+		explainQueue("Window %v budget: %v", t.windowPeriod, budgetDesc)
+		explainQueue("Window %v queue: could buy floor(%v / %v) = %v "+
+			"tickets", t.windowPeriod, budget, curPrice, couldBuy)
 
 		// Override the target price being the average price if
 		// the user has elected to attempt to modify the ticket
 		// price.
 		targetPrice := avgPrice
+		targetSource := "the average price"
 		if t.cfg.PriceTarget > 0.0 {
 			targetPrice = t.cfg.PriceTarget
+			targetSource = "pricetarget"
 		}
 
 		// The target price can not be above the maximum scaled
@@ -349,14 +526,49 @@ func (t *ticketPurchaser) purchase(height int32) error {
 				"was above the allowable scaled maximum of %v, so the "+
 				"scaled maximum is being used as the target",
 				t.cfg.PriceTarget, maxPriceScaledAmt)
+			targetSource = "the scaled maximum price, which is below " +
+				targetSource
 		}
-
-		// Decay exponentially if the price is above the ideal or target
-		// price.
+		if t.cfg.QueueStrategy == queuePenaltyStr {
+			explainQueue("Target price %v from %v", targetPrice,
+				targetSource)
+		}
+		t.targetPrice = targetPrice
+		t.targetSource = targetSource
+
+		// With the ladder strategy, buy the proportion of the tickets
+		// we could possibly buy that is given by the price band the
+		// price falls in. With the target strategy, buy the shortfall
+		// against the target ticket count instead.
+		// Otherwise, decay exponentially if the price is above the
+		// ideal or target price.
 		// floor(penalty ^ -(abs(ticket price - average ticket price)))
 		// Then multiply by the number of tickets we could possibly
 		// buy.
-		if curPrice.ToCoin() > targetPrice {
+		switch {
+		case t.priceLadder != nil:
+			toBuy, band := ladderToBuy(t.priceLadder, curPrice.ToCoin(),
+				couldBuy)
+			t.toBuyDiffPeriod = toBuy
+
+			log.Debugf("The current price %v is in the price ladder band "+
+				"%v, so %v%% of %v tickets have been queued for purchase: "+
+				"%v", curPrice, band, band.percent, couldBuy,
+				t.toBuyDiffPeriod)
+			explainQueue("Price %v in the price ladder band %v: queued "+
+				"floor(%v%% x %v) = %v tickets", curPrice, band, band.percent,
+				couldBuy, t.toBuyDiffPeriod)
+		case t.cfg.QueueStrategy == queueTargetStr:
+			toBuy, desc, err := t.targetToBuy(couldBuy)
+			if err != nil {
+				return nil, err
+			}
+			t.toBuyDiffPeriod = toBuy
+
+			log.Debugf("%v tickets have been queued for purchase to meet "+
+				"the target ticket count: %v", t.toBuyDiffPeriod, desc)
+			explainQueue("Target ticket count: %v", desc)
+		case curPrice.ToCoin() > targetPrice:
 			toBuy := math.Floor(math.Pow(t.cfg.HighPricePenalty,
 				-(math.Abs(curPrice.ToCoin()-targetPrice))) * couldBuy)
 			t.toBuyDiffPeriod = int(float64(toBuy))
@@ -365,7 +577,11 @@ func (t *ticketPurchaser) purchase(height int32) error {
 				"so the number of tickets to buy this window was "+
 				"scaled from %v to %v", curPrice, targetPrice, couldBuy,
 				t.toBuyDiffPeriod)
-		} else {
+			explainQueue("Price %v above the target %v: queued "+
+				"floor(%v^-|%v - %v| x %v) = %v tickets", curPrice,
+				targetPrice, t.cfg.HighPricePenalty, curPrice.ToCoin(),
+				targetPrice, couldBuy, t.toBuyDiffPeriod)
+		default:
 			// Below or equal to the average price. Buy as many
 			// tickets as possible.
 			t.toBuyDiffPeriod = int(float64(couldBuy))
@@ -373,8 +589,29 @@ func (t *ticketPurchaser) purchase(height int32) error {
 			log.Debugf("The stake difficulty %v was below the target penalty "+
 				"cutoff %v; %v many tickets have been queued for purchase",
 				curPrice, targetPrice, t.toBuyDiffPeriod)
+			explainQueue("Price %v at or below the target %v: queued all "+
+				"%v tickets", curPrice, targetPrice, t.toBuyDiffPeriod)
+		}
+
+		// Top up the account from the funding account with what the
+		// queue needs.
+		transferred, err := t.fundWindow(height, t.toBuyDiffPeriod,
+			curPrice, balSpendable, fundable)
+		if err != nil {
+			log.Errorf("Failed to transfer funds from account '%s': %v",
+				t.cfg.FundingAccount, err)
+			explainQueue("Failed to transfer funds from account '%s': %v",
+				t.cfg.FundingAccount, err)
+		} else if transferred > 0 {
+			balSpendable += transferred
+			result.Balance = balSpendable.ToCoin()
+			explainQueue("Transferred %v from account '%s', raising the "+
+				"spendable balance to %v", transferred,
+				t.cfg.FundingAccount, balSpendable)
 		}
+		t.queueExplain = queueExplain
 	}
+	result.Explain = append(result.Explain, t.queueExplain...)
 
 	// Disable purchasing if the ticket price is too high based on
 	// the absolute cutoff or if the estimated ticket price is above
@@ -383,13 +620,23 @@ func (t *ticketPurchaser) purchase(height int32) error {
 		log.Tracef("Aborting ticket purchases because the ticket price %v "+
 			"is higher than the maximum absolute price %v", nextStakeDiff,
 			maxPriceAbsAmt)
-		return nil
+		result.explainf("Next stake difficulty %v is above maxpriceabsolute "+
+			"%v", nextStakeDiff, maxPriceAbsAmt)
+		return t.finishRound(result, reasonPriceAboveAbsMax), nil
 	}
+	result.explainf("Next stake difficulty %v is within maxpriceabsolute %v",
+		nextStakeDiff, maxPriceAbsAmt)
 	if t.maintainMaxPrice && (sDiffEsts.Expected > maxPriceScaledAmt.ToCoin()) {
 		log.Tracef("Aborting ticket purchases because the ticket price "+
 			"next window estimate %v is higher than the maximum scaled "+
 			"price %v", sDiffEsts.Expected, maxPriceScaledAmt)
-		return nil
+		result.explainf("Expected next window estimate %v is above the "+
+			"scaled maximum price %v", sDiffEsts.Expected, maxPriceScaledAmt)
+		return t.finishRound(result, reasonEstimateAboveScaledMax), nil
+	}
+	if t.maintainMaxPrice {
+		result.explainf("Expected next window estimate %v is within the "+
+			"scaled maximum price %v", sDiffEsts.Expected, maxPriceScaledAmt)
 	}
 
 	// If we still have tickets in the memory pool, don't try
@@ -397,7 +644,7 @@ func (t *ticketPurchaser) purchase(height int32) error {
 	if !t.cfg.DontWaitForTickets {
 		inMP, err := t.ownTicketsInMempool()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if inMP > t.cfg.MaxInMempool {
@@ -405,24 +652,35 @@ func (t *ticketPurchaser) purchase(height int32) error {
 				"blockchain before buying more tickets (in mempool: %v,"+
 				" max allowed in mempool %v)", inMP-t.cfg.MaxInMempool,
 				inMP, t.cfg.MaxInMempool)
-			return nil
+			result.explainf("%v own tickets in the mempool, more than "+
+				"maxinmempool %v", inMP, t.cfg.MaxInMempool)
+			return t.finishRound(result, reasonMempoolWait), nil
 		}
+		result.explainf("%v own tickets in the mempool, within "+
+			"maxinmempool %v", inMP, t.cfg.MaxInMempool)
+	} else {
+		result.explainf("Not waiting for tickets in the mempool " +
+			"(dontwaitfortickets)")
 	}
 
 	// If might be the case that there weren't enough recent
 	// blocks to average fees from. Use data from the last
 	// window with the closest difficulty.
 	chainFee := 0.0
+	feeSource := fmt.Sprintf("the %v of the last %v blocks",
+		t.cfg.FeeSource, t.cfg.BlocksToAvg)
 	if t.idxDiffPeriod < t.cfg.BlocksToAvg {
+		feeSource = fmt.Sprintf("the %v of the closest past windows",
+			t.cfg.FeeSource)
 		chainFee, err = t.findClosestFeeWindows(nextStakeDiff.ToCoin(),
 			t.useMedian)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else {
 		chainFee, err = t.findTicketFeeBlocks(t.useMedian)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -441,12 +699,17 @@ func (t *ticketPurchaser) purchase(height int32) error {
 	}
 	feeToUseAmt, err := dcrutil.NewAmount(feeToUse)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = t.dcrwChainSvr.SetTicketFee(feeToUseAmt)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	t.ticketFee = feeToUseAmt
+	result.Fee = feeToUse
+	result.explainf("Ticket fee: %v from %v x feetargetscaling %v, "+
+		"limited to %v-%v: %v per KB", chainFee, feeSource,
+		t.cfg.FeeTargetScaling, t.cfg.MinFee, t.cfg.MaxFee, feeToUse)
 
 	log.Debugf("Mean fee for the last blocks or window period was %v; "+
 		"this was scaled to %v", chainFee, feeToUse)
@@ -454,8 +717,35 @@ func (t *ticketPurchaser) purchase(height int32) error {
 	// Only the maximum number of tickets at each block
 	// should be purchased, as specified by the user.
 	toBuyForBlock := t.toBuyDiffPeriod - t.purchasedDiffPeriod
+	result.Requested = toBuyForBlock
+	limitReason := reasonNone
+	result.explainf("%v of %v queued tickets remain to be purchased",
+		toBuyForBlock, t.toBuyDiffPeriod)
 	if toBuyForBlock > maxPerBlock {
 		toBuyForBlock = maxPerBlock
+		limitReason = reasonMaxPerBlock
+		result.explainf("Limited to %v by maxperblock", maxPerBlock)
+	}
+
+	// Spread the remaining tickets in the queue evenly across the
+	// remaining blocks in the window if pacing is enabled.
+	if t.cfg.PurchasePacing == pacingEvenStr {
+		every := 1
+		if t.cfg.MaxPerBlock < 0 {
+			every = -t.cfg.MaxPerBlock
+		}
+		paced := pacedToBuy(t.toBuyDiffPeriod, t.purchasedDiffPeriod,
+			height, every, maxPerBlock, t.cfg.PacingJitter)
+		if paced < toBuyForBlock {
+			log.Tracef("Pacing purchases to %v tickets for this block "+
+				"(%v tickets remaining over %v blocks)", paced,
+				t.toBuyDiffPeriod-t.purchasedDiffPeriod,
+				blocksLeftInWindow(t.idxDiffPeriod))
+			toBuyForBlock = paced
+			limitReason = reasonPacing
+			result.explainf("Paced to %v over the %v blocks left in the "+
+				"window", paced, blocksLeftInWindow(t.idxDiffPeriod))
+		}
 	}
 
 	// Hijack the number to purchase for this block if we have minimum
@@ -463,6 +753,11 @@ func (t *ticketPurchaser) purchase(height int32) error {
 	if t.maintainMinPrice && toBuyForBlock < maxPerBlock {
 		if sDiffEsts.Expected < minPriceScaledAmt.ToCoin() {
 			toBuyForBlock = maxPerBlock
+			result.Requested = maxPerBlock
+			limitReason = reasonNone
+			result.explainf("Expected next window estimate %v is below "+
+				"the scaled minimum price %v: buying %v to raise it",
+				sDiffEsts.Expected, minPriceScaledAmt, maxPerBlock)
 			log.Debugf("Attempting to manipulate the stake difficulty "+
 				"so that the price does not fall below the set minimum "+
 				"%v (current estimate for next stake difficulty: %v) by "+
@@ -471,11 +766,32 @@ func (t *ticketPurchaser) purchase(height int32) error {
 		}
 	}
 
-	// We've already purchased all the tickets we need to.
+	// Throttle purchases to stay within the caps on outstanding tickets
+	// and coins locked in tickets.
+	if toBuyForBlock > 0 {
+		room, capReason, err := t.outstandingRoom(nextStakeDiff)
+		if err != nil {
+			return nil, err
+		}
+		if room >= 0 && room < toBuyForBlock {
+			log.Debugf("Purchasing %v instead of %v tickets to stay within "+
+				"the outstanding ticket caps (%v)", room, toBuyForBlock,
+				capReason)
+			toBuyForBlock = room
+			limitReason = capReason
+			result.explainf("Limited to %v by %v", room, capReason)
+		}
+	}
+
+	// We've already purchased all the tickets we need to, or
+	// none are scheduled for this block.
 	if toBuyForBlock <= 0 {
-		log.Tracef("All tickets have been purchased, aborting further " +
-			"ticket purchases")
-		return nil
+		if result.Requested <= 0 {
+			log.Tracef("All tickets have been purchased, aborting " +
+				"further ticket purchases")
+			return t.finishRound(result, reasonQueueExhausted), nil
+		}
+		return t.finishRound(result, limitReason), nil
 	}
 
 	// Check our balance versus the amount of tickets we need to buy.
@@ -494,6 +810,10 @@ func (t *ticketPurchaser) purchase(height int32) error {
 
 			toBuyForBlock--
 		}
+		limitReason = reasonBalanceToMaintain
+		result.explainf("Limited to %v to keep balancetomaintain %v of "+
+			"the balance %v at a price of %v", toBuyForBlock,
+			t.cfg.BalanceToMaintain, balSpendable, nextStakeDiff)
 
 		if toBuyForBlock == 0 {
 			log.Tracef("Aborting purchasing of tickets because our balance "+
@@ -502,44 +822,25 @@ func (t *ticketPurchaser) purchase(height int32) error {
 				(balSpendable.ToCoin() - float64(toBuyForBlock)*
 					nextStakeDiff.ToCoin()),
 				t.cfg.BalanceToMaintain)
-			return nil
-		}
-	}
-
-	// If an address wasn't passed, create an internal address in
-	// the wallet for the ticket address.
-	var ticketAddress dcrutil.Address
-	if t.ticketAddress != nil {
-		ticketAddress = t.ticketAddress
-	} else {
-		ticketAddress, err =
-			t.dcrwChainSvr.GetRawChangeAddress(t.cfg.AccountName)
-		if err != nil {
-			return err
+			return t.finishRound(result, reasonBalanceToMaintain), nil
 		}
 	}
 
 	// Purchase tickets.
-	poolFeesAmt, err := dcrutil.NewAmount(t.cfg.PoolFees)
+	tickets, err := t.buyTickets(height, toBuyForBlock, maxPriceAbsAmt)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	minConf := 0
-	expiry := int(height) + t.cfg.ExpiryDelta
-	tickets, err := t.dcrwChainSvr.PurchaseTicket(t.cfg.AccountName,
-		maxPriceAbsAmt,
-		&minConf,
-		ticketAddress,
-		&toBuyForBlock,
-		t.poolAddress,
-		&poolFeesAmt,
-		&expiry)
-	if err != nil {
-		return err
+	result.TicketsBought = len(tickets)
+	t.budgetSpent(len(tickets), nextStakeDiff)
+	if len(tickets) < toBuyForBlock {
+		limitReason = reasonWalletShortfall
+		result.explainf("The wallet purchased %v of the %v tickets "+
+			"requested from it", len(tickets), toBuyForBlock)
 	}
-	t.purchasedDiffPeriod += toBuyForBlock
 
 	for i := range tickets {
+		result.Tickets = append(result.Tickets, tickets[i].String())
 		log.Infof("Purchased ticket %v at stake difficulty %v (%v "+
 			"fees per KB used)", tickets[i], nextStakeDiff.ToCoin(),
 			feeToUseAmt.ToCoin())
@@ -553,10 +854,86 @@ func (t *ticketPurchaser) purchase(height int32) error {
 	balSpendable, err = t.dcrwChainSvr.GetBalanceMinConfType(t.cfg.AccountName,
 		0, "spendable")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	log.Debugf("Final spendable balance at height %v for account '%s' "+
 		"after ticket purchases: %v", height, t.cfg.AccountName, balSpendable)
+	result.Balance = balSpendable.ToCoin()
+
+	return t.finishRound(result, limitReason), nil
+}
+
+// buyTickets purchases numTickets tickets from the wallet, spending no
+// more than spendLimit on each, and records them as purchased in this
+// window. If an address wasn't passed, an internal address in the wallet
+// is created for the ticket address.
+func (t *ticketPurchaser) buyTickets(height int32, numTickets int,
+	spendLimit dcrutil.Amount) ([]*chainhash.Hash, error) {
+	var ticketAddress dcrutil.Address
+	var err error
+	if t.ticketAddress != nil {
+		ticketAddress = t.ticketAddress
+	} else {
+		ticketAddress, err =
+			t.dcrwChainSvr.GetRawChangeAddress(t.cfg.AccountName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	poolFeesAmt, err := dcrutil.NewAmount(t.cfg.PoolFees)
+	if err != nil {
+		return nil, err
+	}
+	minConf := 0
+	expiryHeight, err := t.ticketExpiry(height)
+	if err != nil {
+		return nil, err
+	}
+	expiry := int(expiryHeight)
+	var tickets []*chainhash.Hash
+	err = t.withUnlockedWallet(func() error {
+		var err error
+		tickets, err = t.dcrwChainSvr.PurchaseTicket(t.cfg.AccountName,
+			spendLimit,
+			&minConf,
+			ticketAddress,
+			&numTickets,
+			t.poolAddress,
+			&poolFeesAmt,
+			&expiry)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.purchasedDiffPeriod += numTickets
+	t.trackExpiry(tickets, expiryHeight)
+
+	return tickets, nil
+}
+
+// withUnlockedWallet calls f with the wallet unlocked if the wallet
+// passphrase is held for autounlock, relocking the wallet afterwards. The
+// wallet is unlocked for at most the unlock timeout, so that it relocks
+// itself even if the ticket buyer fails to. Without autounlock, f is
+// called directly.
+func (t *ticketPurchaser) withUnlockedWallet(f func() error) error {
+	if t.walletPass == nil {
+		return f()
+	}
+
+	err := t.dcrwChainSvr.WalletPassphrase(string(t.walletPass),
+		int64(t.cfg.UnlockTimeout))
+	if err != nil {
+		return purchaseErrorf(ErrWalletLocked, "Failed to unlock the "+
+			"wallet: %v", err)
+	}
+	defer func() {
+		if err := t.dcrwChainSvr.WalletLock(); err != nil {
+			log.Errorf("Failed to relock the wallet: %v", err)
+		}
+	}()
 
-	return nil
+	return f()
 }
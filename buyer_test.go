@@ -0,0 +1,293 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/cjepson/dcrticketbuyer/rpctest"
+	"github.com/decred/dcrd/dcrjson"
+)
+
+// TestPurchaseRounds ensures that the tickets queued for a window are
+// purchased over the following blocks with the right count, spend limit
+// and expiry. The chain starts 7 blocks before the end of a window, so the
+// blocks connected are the 6th to 3rd last of the window.
+func TestPurchaseRounds(t *testing.T) {
+	winSize := activeNet.StakeDiffWindowSize
+	start := 3*winSize - 7
+	windowEnd := int(3 * winSize)
+
+	tests := []struct {
+		name       string
+		configure  func(cfg *config)
+		stakeInfo  dcrjson.GetStakeInfoResult
+		wantCounts []int
+		wantExpiry []int
+	}{
+		{
+			name:       "greedy",
+			configure:  func(cfg *config) {},
+			wantCounts: []int{3, 3, 3, 1},
+			wantExpiry: []int{windowEnd, windowEnd, windowEnd, windowEnd},
+		},
+		{
+			name: "fixed expiry",
+			configure: func(cfg *config) {
+				cfg.ExpiryMode = expiryFixedStr
+			},
+			wantCounts: []int{3, 3, 3, 1},
+			wantExpiry: []int{
+				int(start) + 1 + defaultExpiryDelta,
+				int(start) + 2 + defaultExpiryDelta,
+				int(start) + 3 + defaultExpiryDelta,
+				int(start) + 4 + defaultExpiryDelta,
+			},
+		},
+		{
+			name: "one every other block",
+			configure: func(cfg *config) {
+				cfg.MaxPerBlock = -2
+			},
+			wantCounts: []int{1, 1},
+			wantExpiry: []int{windowEnd, windowEnd},
+		},
+		{
+			name: "price above absolute maximum",
+			configure: func(cfg *config) {
+				cfg.MaxPriceAbsolute = 5.0
+			},
+		},
+		{
+			name: "max outstanding",
+			configure: func(cfg *config) {
+				cfg.MaxOutstanding = 5
+			},
+			stakeInfo:  dcrjson.GetStakeInfoResult{Live: 3},
+			wantCounts: []int{2},
+			wantExpiry: []int{windowEnd},
+		},
+	}
+
+	for _, test := range tests {
+		h := newTestHarness(t, start)
+		h.Wallet.SetStakeInfo(test.stakeInfo)
+		cfg := newTestConfig(h)
+		test.configure(cfg)
+		b := startTestBuyer(t, h, cfg, nil, false)
+		for i := 0; i < 4; i++ {
+			b.connectBlock()
+		}
+		b.stop()
+
+		purchases := h.Wallet.Purchases()
+		if len(purchases) != len(test.wantCounts) {
+			t.Errorf("%s: got %v purchases, want %v", test.name,
+				len(purchases), len(test.wantCounts))
+			continue
+		}
+		for i, p := range purchases {
+			if p.NumTickets != test.wantCounts[i] {
+				t.Errorf("%s: purchase %v: got %v tickets, want %v",
+					test.name, i, p.NumTickets, test.wantCounts[i])
+			}
+			if p.SpendLimit != cfg.MaxPriceAbsolute {
+				t.Errorf("%s: purchase %v: got spend limit %v, want %v",
+					test.name, i, p.SpendLimit, cfg.MaxPriceAbsolute)
+			}
+			if p.Expiry != test.wantExpiry[i] {
+				t.Errorf("%s: purchase %v: got expiry %v, want %v",
+					test.name, i, p.Expiry, test.wantExpiry[i])
+			}
+		}
+		if len(purchases) > 0 {
+			wantFee := 0.01 * cfg.FeeTargetScaling
+			if math.Abs(h.Wallet.TicketFee()-wantFee) > 1e-8 {
+				t.Errorf("%s: got ticket fee %v, want %v", test.name,
+					h.Wallet.TicketFee(), wantFee)
+			}
+		}
+	}
+}
+
+// TestPurchaseWalletState ensures that tickets are only purchased while
+// the wallet is unlocked and connected to its daemon, and that autounlock
+// unlocks the wallet for the purchase and relocks it afterwards.
+func TestPurchaseWalletState(t *testing.T) {
+	tests := []struct {
+		name          string
+		locked        bool
+		disconnected  bool
+		walletPass    []byte
+		wantPurchases int
+		wantUnlocks   int
+	}{
+		{"unlocked", false, false, nil, 1, 0},
+		{"locked", true, false, nil, 0, 0},
+		{"autounlock", true, false, []byte("passphrase"), 1, 1},
+		{"not connected", false, true, nil, 0, 0},
+	}
+
+	for _, test := range tests {
+		h := newTestHarness(t, 300)
+		h.Wallet.SetLocked(test.locked)
+		h.Wallet.SetDaemonConnected(!test.disconnected)
+		b := startTestBuyer(t, h, newTestConfig(h), test.walletPass, false)
+		b.connectBlock()
+		b.stop()
+
+		if got := len(h.Wallet.Purchases()); got != test.wantPurchases {
+			t.Errorf("%s: got %v purchases, want %v", test.name, got,
+				test.wantPurchases)
+		}
+		if got := h.Wallet.Unlocks(); got != test.wantUnlocks {
+			t.Errorf("%s: got %v unlocks, want %v", test.name, got,
+				test.wantUnlocks)
+		}
+		if got := h.Wallet.CallCount("walletlock"); got != test.wantUnlocks {
+			t.Errorf("%s: got %v relocks, want %v", test.name, got,
+				test.wantUnlocks)
+		}
+	}
+}
+
+// TestPurchaseRPCFailure ensures that a failing RPC aborts the purchase
+// round it fails in and that purchasing resumes once the RPC recovers.
+func TestPurchaseRPCFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func(h *rpctest.Harness, err error)
+	}{
+		{"daemon", func(h *rpctest.Harness, err error) {
+			h.Dcrd.Fail("estimatestakediff", err)
+		}},
+		{"wallet", func(h *rpctest.Harness, err error) {
+			h.Wallet.Fail("purchaseticket", err)
+		}},
+	}
+
+	for _, test := range tests {
+		h := newTestHarness(t, 300)
+		b := startTestBuyer(t, h, newTestConfig(h), nil, false)
+		b.connectBlock()
+		test.fail(h, errors.New("scripted failure"))
+		b.connectBlock()
+		test.fail(h, nil)
+		b.connectBlock()
+		b.stop()
+
+		purchases := h.Wallet.Purchases()
+		if len(purchases) != 2 {
+			t.Errorf("%s: got %v purchases, want 2", test.name,
+				len(purchases))
+			continue
+		}
+		for i, p := range purchases {
+			if p.NumTickets != defaultMaxPerBlock {
+				t.Errorf("%s: purchase %v: got %v tickets, want %v",
+					test.name, i, p.NumTickets, defaultMaxPerBlock)
+			}
+		}
+	}
+}
+
+// TestConsolidate ensures that the small outputs of the account are
+// consolidated into one output before the next window opens.
+func TestConsolidate(t *testing.T) {
+	winSize := activeNet.StakeDiffWindowSize
+	h := newTestHarness(t, 3*winSize-defaultConsolidateBlocks-2)
+	h.Wallet.SetUnspent([]dcrjson.ListUnspentResult{
+		{TxID: testTxID(1), Account: "default", Amount: 1, Spendable: true},
+		{TxID: testTxID(2), Account: "default", Amount: 2, Spendable: true},
+		{TxID: testTxID(3), Account: "default", Amount: 3, Spendable: true},
+		{TxID: testTxID(4), Account: "default", Amount: 50, Spendable: true},
+	})
+	cfg := newTestConfig(h)
+	cfg.Consolidate = true
+	cfg.MaxPerBlock = 0
+	b := startTestBuyer(t, h, cfg, nil, false)
+	b.connectBlock()
+	b.connectBlock()
+	b.stop()
+
+	published := h.Dcrd.Published()
+	if len(published) != 1 {
+		t.Fatalf("got %v published transactions, want 1", len(published))
+	}
+	tx := published[0]
+	if len(tx.TxIn) != 3 || len(tx.TxOut) != 1 {
+		t.Fatalf("got %v inputs and %v outputs, want 3 and 1",
+			len(tx.TxIn), len(tx.TxOut))
+	}
+	if tx.TxOut[0].Value >= 6e8 || tx.TxOut[0].Value < 6e8-1e6 {
+		t.Errorf("got output of %v atoms, want 6 coins less the fee",
+			tx.TxOut[0].Value)
+	}
+}
+
+// TestPreSplit ensures that outputs sized for a ticket at the next stake
+// difficulty of 12 coins are created at the last block of a window, one
+// for each ticket the next window's budget affords up to presplitoutputs.
+func TestPreSplit(t *testing.T) {
+	winSize := activeNet.StakeDiffWindowSize
+	tests := []struct {
+		name        string
+		start       int64
+		outputs     int
+		budget      float64
+		wantOutputs int
+	}{
+		{"limited by presplitoutputs", 3*winSize - 2, 2, 0, 2},
+		{"limited by the balance", 3*winSize - 2, 10, 0, 8},
+		{"limited by the budget", 3*winSize - 2, 10, 40, 3},
+		{"block before the last block of the window", 3*winSize - 3, 2, 0,
+			0},
+	}
+
+	for _, test := range tests {
+		h := newTestHarness(t, test.start)
+		h.Dcrd.SetStakeDifficulty(10, 12)
+		cfg := newTestConfig(h)
+		cfg.PreSplitOutputs = test.outputs
+		if test.budget > 0 {
+			cfg.BudgetMode = budgetFixedStr
+			cfg.BudgetAmount = test.budget
+		}
+		b := startTestBuyer(t, h, cfg, nil, false)
+		b.connectBlock()
+		b.stop()
+
+		sends := h.Wallet.Sends()
+		if test.wantOutputs == 0 {
+			if len(sends) != 0 {
+				t.Errorf("%s: got sends %v, want none", test.name, sends)
+			}
+			continue
+		}
+		if len(sends) != 1 || len(sends[0]) != test.wantOutputs {
+			t.Errorf("%s: got sends %v, want one of %v outputs", test.name,
+				sends, test.wantOutputs)
+			continue
+		}
+		for addr, amt := range sends[0] {
+			if amt <= 12 || amt > 12.01 {
+				t.Errorf("%s: got output of %v to %v, want 12 coins and a "+
+					"ticket fee", test.name, amt, addr)
+			}
+		}
+	}
+}
+
+// testTxID returns a transaction hash for test outputs.
+func testTxID(n byte) string {
+	var txID [64]byte
+	for i := range txID {
+		txID[i] = '0'
+	}
+	txID[63] = '0' + n
+	return string(txID[:])
+}
@@ -21,6 +21,7 @@ const (
 	defaultLogLevel       = "info"
 	defaultLogDirname     = "logs"
 	defaultLogFilename    = "ticketbuyer.log"
+	defaultDataDirname    = "data"
 	currentVersion        = 1
 )
 
@@ -36,6 +37,7 @@ var (
 	defaultWalletRPCKeyFile  = filepath.Join(dcrwalletHomeDir, "rpc.key")
 	defaultWalletRPCCertFile = filepath.Join(dcrwalletHomeDir, "rpc.cert")
 	defaultLogDir            = filepath.Join(curDir, defaultLogDirname)
+	defaultDataDir           = filepath.Join(curDir, defaultDataDirname)
 	defaultHost              = "localhost"
 
 	defaultAccountName        = "default"
@@ -52,29 +54,78 @@ var (
 	defaultMaxPerBlock        = 3
 	defaultBalanceToMaintain  = 0.0
 	defaultHighPricePenalty   = 1.3
+	defaultQueueStrategy      = "penalty"
+	defaultPriceLadder        = ""
+	defaultTargetTickets      = 0
+	defaultTargetWindows      = 1
+	defaultBudgetMode         = "none"
+	defaultBudgetPercent      = 100.0
+	defaultBudgetAmount       = 0.0
+	defaultBudgetWindows      = 1
+	defaultFundingAccount     = ""
+	defaultFundingMaxTransfer = 0.0
 	defaultBlocksToAvg        = 11
 	defaultFeeTargetScaling   = 1.05
 	defaultDontWaitForTickets = false
 	defaultMaxInMempool       = 0
+	defaultMaxOutstanding     = 0
+	defaultMaxLocked          = 0.0
+	defaultConsolidate        = false
+	defaultConsolidateBlocks  = 6
+	defaultPreSplitOutputs    = 0
 	defaultExpiryDelta        = 16
+	defaultExpiryMode         = "window"
+	defaultStakeDiffSource    = "dcrd"
+	defaultAvgPriceMode       = "dual"
+	defaultAvgPriceVWAPDelta  = 0
+	defaultAvgPriceVWAPWeight = 1.0
+	defaultAvgPricePoolWeight = 1.0
+	defaultAvgPriceWindows    = 10
+	defaultPurchasePacing     = "greedy"
+	defaultPacingJitter       = 0.0
+	defaultSplitMode          = "none"
+	defaultSplitListen        = "localhost:9120"
+	defaultSplitCoordinator   = "localhost:9120"
+	defaultControlListen      = ""
+	defaultAutoUnlock         = false
+	defaultUnlockTimeout      = 60
 )
 
 type config struct {
 	// General application behavior
 	ConfigFile  string `short:"C" long:"configfile" description:"Path to configuration file"`
 	ShowVersion bool   `short:"V" long:"version" description:"Display version information and exit"`
+	CheckConfig bool   `long:"checkconfig" description:"Validate the configuration, print every effective setting with its source and exit"`
 	TestNet     bool   `long:"testnet" description:"Use the test network (default mainnet)"`
 	SimNet      bool   `long:"simnet" description:"Use the simulation test network (default mainnet)"`
-	DebugLevel  string `short:"d" long:"debuglevel" description:"Logging level {trace, debug, info, warn, error, critical}"`
+	DebugLevel  string `short:"d" long:"debuglevel" description:"Logging level {trace, debug, info, warn, error, critical}, optionally suffixed with :json to also write to the JSON log"`
 	LogDir      string `long:"logdir" description:"Directory to log output"`
+	DataDir     string `long:"datadir" description:"Directory to store the history database in"`
+	NoHistory   bool   `long:"nohistory" description:"Do not store block observations and purchase decisions in the history database"`
+	TUI         bool   `long:"tui" description:"Display a live dashboard in the terminal instead of logging to the console"`
+	PIDFile     string `long:"pidfile" description:"File to write the process ID to while running"`
+
+	// Control server options
+	ControlListen string `long:"controllisten" description:"Interface/port for the control server that commands such as buy use to talk to the running ticket buyer, e.g. localhost:9121 (default: disabled)"`
+	ControlToken  string `long:"controltoken" description:"Shared secret that commands must present to the control server"`
+
+	// Watch-only options
+	WatchOnly       bool     `long:"watchonly" description:"Track the tickets paying to the watch addresses with dcrd alone instead of purchasing tickets"`
+	WatchAddresses  []string `long:"watchaddress" description:"Ticket address to track in watch-only mode, may be given multiple times (default: ticketaddress)"`
+	WatchFromHeight int64    `long:"watchfromheight" description:"Height to scan the blockchain from at startup for tickets paying to the watch addresses (default: 0, 0 to scan the blocks of the ticket maturity and expiry window)"`
 
 	// RPC client options
 	DcrdUser         string `long:"dcrduser" description:"Daemon RPC user name"`
 	DcrdPass         string `long:"dcrdpass" description:"Daemon RPC password"`
+	DcrdPassFile     string `long:"dcrdpassfile" description:"File containing the daemon RPC password, which must not be readable by all users"`
 	DcrdServ         string `long:"dcrdserv" description:"Hostname/IP and port of dcrd RPC server to connect to (default localhost:9109, testnet: localhost:19109, simnet: localhost:19556)"`
 	DcrdCert         string `long:"dcrdcert" description:"File containing the dcrd certificate file"`
 	DcrwUser         string `long:"dcrwuser" description:"Wallet RPC user name"`
 	DcrwPass         string `long:"dcrwpass" description:"Wallet RPC password"`
+	DcrwPassFile     string `long:"dcrwpassfile" description:"File containing the wallet RPC password, which must not be readable by all users"`
+	SecretsFile      string `long:"secretsfile" description:"Encrypted file containing the RPC passwords, unlocked with a passphrase at startup (create it with the createsecrets command)"`
+	AutoUnlock       bool   `long:"autounlock" description:"Hold the wallet passphrase in memory, read from the secrets file or prompted for at startup, and only unlock the wallet while purchasing tickets"`
+	UnlockTimeout    int    `long:"unlocktimeout" description:"Number of seconds to unlock the wallet for when purchasing tickets with autounlock (default: 60)"`
 	DcrwServ         string `long:"dcrwserv" description:"Hostname/IP and port of dcrwallet RPC server to connect to (default localhost:9110, testnet: localhost:19110, simnet: localhost:19557)"`
 	DcrwCert         string `long:"dcrwcert" description:"File containing the dcrwallet certificate file"`
 	DisableClientTLS bool   `long:"noclienttls" description:"Disable TLS for the RPC client -- NOTE: This is only allowed if the RPC client is connecting to localhost"`
@@ -95,11 +146,40 @@ type config struct {
 	MaxPerBlock        int     `long:"maxperblock" description:"Maximum tickets per block, with negative numbers indicating buy one ticket every 1-in-n blocks (default: 3)"`
 	BalanceToMaintain  float64 `long:"balancetomaintain" description:"Balance to try to maintain in the wallet"`
 	HighPricePenalty   float64 `long:"highpricepenalty" description:"The exponential penalty to apply to the number of tickets to purchase above the mean ticket pool price (default: 1.3)"`
+	QueueStrategy      string  `long:"queuestrategy" description:"How to size the queue of tickets to buy in each window from the tickets that can be afforded (penalty to decay with highpricepenalty above the target price, ladder to buy the proportion given by the band of priceladder that the price falls in, or target to buy the shortfall against targettickets, default: penalty)"`
+	PriceLadder        string  `long:"priceladder" description:"Price bands of the ladder strategy as comma separated min-max:percent, starting at 0 and ending with a band without a maximum, e.g. 0-80:100,80-100:50,100-:0"`
+	TargetTickets      int     `long:"targettickets" description:"The number of live, immature and unmined tickets to maintain with the target strategy"`
+	TargetWindows      int     `long:"targetwindows" description:"The number of windows to spread the purchase of the shortfall against targettickets over with the target strategy (default: 1)"`
+	BudgetMode         string  `long:"budgetmode" description:"How much to spend on the tickets queued for each window (none for the whole spendable balance, percent for budgetpercent of it, fixed for budgetamount per window, or dca to spread budgetamount over budgetwindows windows, default: none)"`
+	BudgetPercent      float64 `long:"budgetpercent" description:"The percentage of the spendable balance to spend in each window in the percent budget mode (default: 100.0)"`
+	BudgetAmount       float64 `long:"budgetamount" description:"The amount of coins to spend in each window in the fixed budget mode, or in total in the dca budget mode"`
+	BudgetWindows      int     `long:"budgetwindows" description:"The number of windows to spread budgetamount over in the dca budget mode (default: 1)"`
+	FundingAccount     string  `long:"fundingaccount" description:"Account to transfer what the tickets queued for each window need to the purchasing account from, right before the window opens"`
+	FundingMaxTransfer float64 `long:"fundingmaxtransfer" description:"The maximum amount of coins to transfer from the funding account in each window (default: 0.0, 0.0 for no limit)"`
 	BlocksToAvg        int     `long:"blockstoavg" description:"Number of blocks to average for fees calculation (default: 11)"`
 	FeeTargetScaling   float64 `long:"feetargetscaling" description:"The amount above the mean fee in the previous blocks to purchase tickets with, proportional e.g. 1.05 = 105% (default: 1.05)"`
 	DontWaitForTickets bool    `long:"dontwaitfortickets" description:"Don't wait until your last round of tickets have entered the blockchain to attempt to purchase more"`
 	MaxInMempool       int     `long:"maxinmempool" description:"The maximum number of tickets allowed in mempool before purchasing more tickets (default: 0)"`
+	MaxOutstanding     int     `long:"maxoutstanding" description:"The maximum number of live, immature and unmined tickets to own before purchasing more tickets (default: 0, 0 to disable)"`
+	MaxLocked          float64 `long:"maxlocked" description:"The maximum amount of coins to have locked in tickets before purchasing more tickets (default: 0.0, 0.0 to disable)"`
+	Consolidate        bool    `long:"consolidate" description:"Consolidate the small outputs of the account into outputs sized near the next window's expected stake difficulty before the window opens, paying txfee"`
+	ConsolidateBlocks  int     `long:"consolidateblocks" description:"Number of blocks before the next window opens to consolidate outputs in (default: 6)"`
+	PreSplitOutputs    int     `long:"presplitoutputs" description:"Maximum number of outputs sized exactly for one ticket at the next window's stake difficulty and its fee to create at the last block of each window, one for each ticket the next window's budget affords (default: 0, 0 to disable)"`
 	ExpiryDelta        int     `long:"expirydelta" description:"Number of blocks in the future before the ticket expires (default: 16)"`
+	ExpiryMode         string  `long:"expirymode" description:"How to set the expiry of tickets (fixed for expirydelta blocks, window for expirydelta blocks but never past the end of the stake difficulty window, or dynamic to also add the blocks needed to mine the tickets in the mempool, default: window)"`
+	StakeDiffSource    string  `long:"stakediffsource" description:"The source of the next window stake difficulty estimate used for the maxpricescale and minpricescale checks (dcrd or local, default: dcrd)"`
+	AvgPriceMode       string  `long:"avgpricemode" description:"The model used to calculate the average ticket price (vwap, pool, dual, median or file, default: dual)"`
+	AvgPriceVWAPDelta  int     `long:"avgpricevwapdelta" description:"Number of blocks before the current height to calculate the VWAP over (default: 0, 0 to use the daemon default)"`
+	AvgPriceVWAPWeight float64 `long:"avgpricevwapweight" description:"The weight of the VWAP in the dual average price model (default: 1.0)"`
+	AvgPricePoolWeight float64 `long:"avgpricepoolweight" description:"The weight of the ticket pool mean price in the dual average price model (default: 1.0)"`
+	AvgPriceWindows    int     `long:"avgpricewindows" description:"Number of windows to take the median stake difficulty of in the median average price model (default: 10)"`
+	AvgPriceFile       string  `long:"avgpricefile" description:"File containing the average ticket price in coins for the file average price model"`
+	PurchasePacing     string  `long:"purchasepacing" description:"How to pace the purchase of the tickets queued for a window (greedy to buy as fast as maxperblock allows, or even to spread them across the window, default: greedy)"`
+	PacingJitter       float64 `long:"pacingjitter" description:"The proportion of random jitter to apply to even purchase pacing (0.0 to 1.0, default: 0.0)"`
+	SplitMode          string  `long:"splitmode" description:"Take part in split tickets with other users (none, coordinator or participant, default: none)"`
+	SplitListen        string  `long:"splitlisten" description:"Interface/port for the split ticket coordinator to listen on (default: localhost:9120)"`
+	SplitCoordinator   string  `long:"splitcoordinator" description:"Hostname/IP and port of the split ticket coordinator to contribute to (default: localhost:9120)"`
+	SplitAmount        float64 `long:"splitamount" description:"The amount of coins to contribute to each split ticket as a participant"`
 }
 
 // cleanAndExpandPath expands environement variables and leading ~ in the
@@ -151,20 +231,27 @@ func supportedSubsystems() []string {
 }
 
 // parseAndSetDebugLevels attempts to parse the specified debug level and set
-// the levels accordingly.  An appropriate error is returned if anything is
-// invalid.
+// the levels accordingly.  A level suffixed with :json additionally writes
+// the messages of the subsystems it applies to to the JSON log.  An
+// appropriate error is returned if anything is invalid.
 func parseAndSetDebugLevels(debugLevel string) error {
 	// When the specified string doesn't have any delimters, treat it as
 	// the log level for all subsystems.
 	if !strings.Contains(debugLevel, ",") && !strings.Contains(debugLevel, "=") {
 		// Validate debug log level.
-		if !validLogLevel(debugLevel) {
+		logLevel, useJSON := parseLogLevel(debugLevel)
+		if !validLogLevel(logLevel) {
 			str := "The specified debug level [%v] is invalid"
 			return fmt.Errorf(str, debugLevel)
 		}
 
 		// Change the logging level for all subsystems.
-		setLogLevels(debugLevel)
+		setLogLevels(logLevel)
+		if useJSON {
+			for _, subsysID := range supportedSubsystems() {
+				enableJSONLog(subsysID)
+			}
+		}
 
 		return nil
 	}
@@ -181,6 +268,7 @@ func parseAndSetDebugLevels(debugLevel string) error {
 		// Extract the specified subsystem and log level.
 		fields := strings.Split(logLevelPair, "=")
 		subsysID, logLevel := fields[0], fields[1]
+		logLevel, useJSON := parseLogLevel(logLevel)
 
 		// Validate subsystem.
 		if _, exists := subsystemLoggers[subsysID]; !exists {
@@ -196,23 +284,22 @@ func parseAndSetDebugLevels(debugLevel string) error {
 		}
 
 		setLogLevel(subsysID, logLevel)
+		if useJSON {
+			enableJSONLog(subsysID)
+		}
 	}
 
 	return nil
 }
 
-// loadConfig initializes and parses the config using a config file and command
-// line options.
-func loadConfig() (*config, error) {
-	loadConfigError := func(err error) (*config, error) {
-		return nil, err
-	}
-
-	// Default config.
-	cfg := config{
+// defaultConfig returns the configuration that the config file and command
+// line options are applied to.
+func defaultConfig() config {
+	return config{
 		DebugLevel:         defaultLogLevel,
 		ConfigFile:         defaultConfigFile,
 		LogDir:             defaultLogDir,
+		DataDir:            defaultDataDir,
 		DcrdCert:           defaultDaemonRPCCertFile,
 		DcrwCert:           defaultWalletRPCCertFile,
 		AccountName:        defaultAccountName,
@@ -229,13 +316,55 @@ func loadConfig() (*config, error) {
 		MaxPerBlock:        defaultMaxPerBlock,
 		BalanceToMaintain:  defaultBalanceToMaintain,
 		HighPricePenalty:   defaultHighPricePenalty,
+		QueueStrategy:      defaultQueueStrategy,
+		PriceLadder:        defaultPriceLadder,
+		TargetTickets:      defaultTargetTickets,
+		TargetWindows:      defaultTargetWindows,
+		BudgetMode:         defaultBudgetMode,
+		BudgetPercent:      defaultBudgetPercent,
+		BudgetAmount:       defaultBudgetAmount,
+		BudgetWindows:      defaultBudgetWindows,
+		FundingAccount:     defaultFundingAccount,
+		FundingMaxTransfer: defaultFundingMaxTransfer,
 		BlocksToAvg:        defaultBlocksToAvg,
 		FeeTargetScaling:   defaultFeeTargetScaling,
 		DontWaitForTickets: defaultDontWaitForTickets,
 		MaxInMempool:       defaultMaxInMempool,
+		MaxOutstanding:     defaultMaxOutstanding,
+		MaxLocked:          defaultMaxLocked,
+		Consolidate:        defaultConsolidate,
+		ConsolidateBlocks:  defaultConsolidateBlocks,
+		PreSplitOutputs:    defaultPreSplitOutputs,
 		ExpiryDelta:        defaultExpiryDelta,
+		ExpiryMode:         defaultExpiryMode,
+		StakeDiffSource:    defaultStakeDiffSource,
+		AvgPriceMode:       defaultAvgPriceMode,
+		AvgPriceVWAPDelta:  defaultAvgPriceVWAPDelta,
+		AvgPriceVWAPWeight: defaultAvgPriceVWAPWeight,
+		AvgPricePoolWeight: defaultAvgPricePoolWeight,
+		AvgPriceWindows:    defaultAvgPriceWindows,
+		PurchasePacing:     defaultPurchasePacing,
+		PacingJitter:       defaultPacingJitter,
+		SplitMode:          defaultSplitMode,
+		SplitListen:        defaultSplitListen,
+		SplitCoordinator:   defaultSplitCoordinator,
+		ControlListen:      defaultControlListen,
+		AutoUnlock:         defaultAutoUnlock,
+		UnlockTimeout:      defaultUnlockTimeout,
+	}
+}
+
+// loadConfig initializes and parses the config using a config file and command
+// line options.  The remaining command line arguments, which name a command
+// to run instead of the ticket buyer, are returned as well.
+func loadConfig() (*config, []string, error) {
+	loadConfigError := func(err error) (*config, []string, error) {
+		return nil, nil, err
 	}
 
+	// Default config.
+	cfg := defaultConfig()
+
 	// A config file in the current directory takes precedence.
 	exists := false
 	if _, err := os.Stat(defaultConfigFilename); !os.IsNotExist(err) {
@@ -245,6 +374,7 @@ func loadConfig() (*config, error) {
 	if exists {
 		cfg.ConfigFile = defaultConfigFile
 	}
+	defaultCfg := cfg
 
 	// Pre-parse the command line options to see if an alternative config
 	// file or the version flag was specified.
@@ -282,9 +412,10 @@ func loadConfig() (*config, error) {
 		}
 		configFileError = err
 	}
+	fileCfg := cfg
 
 	// Parse command line options again to ensure they take precedence.
-	_, err = parser.Parse()
+	remainingArgs, err := parser.Parse()
 	if err != nil {
 		if e, ok := err.(*flags.Error); !ok || e.Type != flags.ErrHelp {
 			parser.WriteHelp(os.Stderr)
@@ -320,14 +451,32 @@ func loadConfig() (*config, error) {
 		return loadConfigError(err)
 	}
 
-	// If the user has set a pool address, the pool fees for the
-	// pool can not be zero.
-	if cfg.PoolAddress != "" && cfg.PoolFees == 0.0 {
-		str := "%s: Pool address is set but pool fees are unset or 0.00%%"
-		err := fmt.Errorf(str, "loadConfig")
-		fmt.Fprintln(os.Stderr, err)
-		parser.WriteHelp(os.Stderr)
-		return loadConfigError(err)
+	if cfg.AvgPriceFile != "" {
+		cfg.AvgPriceFile = cleanAndExpandPath(cfg.AvgPriceFile)
+	}
+
+	// Expand the paths of the files containing credentials.
+	if cfg.DcrdPassFile != "" {
+		cfg.DcrdPassFile = cleanAndExpandPath(cfg.DcrdPassFile)
+	}
+	if cfg.DcrwPassFile != "" {
+		cfg.DcrwPassFile = cleanAndExpandPath(cfg.DcrwPassFile)
+	}
+	if cfg.SecretsFile != "" {
+		cfg.SecretsFile = cleanAndExpandPath(cfg.SecretsFile)
+	}
+	if cfg.PIDFile != "" {
+		cfg.PIDFile = cleanAndExpandPath(cfg.PIDFile)
+	}
+
+	// Refuse to use a config file containing passwords that every user
+	// can read.
+	if fileCfg.DcrdPass != "" || fileCfg.DcrwPass != "" {
+		if err := checkSecretFilePerms(preCfg.ConfigFile); err != nil {
+			err := fmt.Errorf("%s: %v", "loadConfig", err)
+			fmt.Fprintln(os.Stderr, err)
+			return loadConfigError(err)
+		}
 	}
 
 	// Set the host names and ports to the default if the
@@ -343,6 +492,8 @@ func loadConfig() (*config, error) {
 	// per network.
 	cfg.LogDir = cleanAndExpandPath(cfg.LogDir)
 	cfg.LogDir = filepath.Join(cfg.LogDir, activeNet.Name)
+	cfg.DataDir = cleanAndExpandPath(cfg.DataDir)
+	cfg.DataDir = filepath.Join(cfg.DataDir, activeNet.Name)
 
 	// Special show command to list supported subsystems and exit.
 	if cfg.DebugLevel == "show" {
@@ -351,7 +502,8 @@ func loadConfig() (*config, error) {
 	}
 
 	// Initialize logging at the default logging level.
-	initSeelogLogger(filepath.Join(cfg.LogDir, defaultLogFilename))
+	initSeelogLogger(filepath.Join(cfg.LogDir, defaultLogFilename), !cfg.TUI)
+	initJSONLogger(filepath.Join(cfg.LogDir, defaultJSONLogFilename))
 	setLogLevels(defaultLogLevel)
 
 	// Parse, validate, and set debug log level(s).
@@ -362,5 +514,42 @@ func loadConfig() (*config, error) {
 		return loadConfigError(err)
 	}
 
-	return &cfg, nil
+	// Passwords given on the command line are visible to other users in
+	// the process list.
+	if preCfg.DcrdPass != "" || preCfg.DcrwPass != "" {
+		log.Warnf("RPC passwords given on the command line are visible " +
+			"to other users, use dcrdpassfile and dcrwpassfile, the " +
+			dcrdPassEnv + " and " + dcrwPassEnv + " environment " +
+			"variables or secretsfile instead")
+	}
+
+	// Watch the ticket address if no watch addresses are given.
+	if cfg.WatchOnly && len(cfg.WatchAddresses) == 0 &&
+		cfg.TicketAddress != "" {
+		cfg.WatchAddresses = []string{cfg.TicketAddress}
+	}
+
+	// Validate the settings.  The check config command prints every
+	// setting along with any problems and exits.
+	errs := validateConfig(&cfg)
+	if cfg.CheckConfig {
+		printConfig(os.Stdout, &cfg, configSources(&defaultCfg, &fileCfg,
+			&preCfg))
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", "loadConfig", err)
+		}
+		if len(errs) != 0 {
+			os.Exit(1)
+		}
+		fmt.Println("Configuration is valid")
+		os.Exit(0)
+	}
+	if len(errs) != 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", "loadConfig", err)
+		}
+		return loadConfigError(fmt.Errorf("%s: %v", "loadConfig", errs[0]))
+	}
+
+	return &cfg, remainingArgs, nil
 }
@@ -0,0 +1,343 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/decred/dcrutil"
+)
+
+const (
+	// minPoolFees and maxPoolFees are the range of the pool fee percentage.
+	minPoolFees = 0.01
+	maxPoolFees = 100.0
+)
+
+// Sources of the effective value of a setting.
+const (
+	configSourceDefault = "default"
+	configSourceFile    = "file"
+	configSourceFlag    = "flag"
+)
+
+// validateConfig checks the settings for values that are out of range or
+// contradict each other, returning an error for each problem found.
+func validateConfig(cfg *config) []error {
+	var errs []error
+	invalid := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	// Amounts in coins.
+	amounts := []struct {
+		name  string
+		value float64
+	}{
+		{"maxpriceabsolute", cfg.MaxPriceAbsolute},
+		{"pricetarget", cfg.PriceTarget},
+		{"maxfee", cfg.MaxFee},
+		{"minfee", cfg.MinFee},
+		{"txfee", cfg.TxFee},
+		{"balancetomaintain", cfg.BalanceToMaintain},
+		{"maxlocked", cfg.MaxLocked},
+		{"splitamount", cfg.SplitAmount},
+		{"budgetamount", cfg.BudgetAmount},
+		{"fundingmaxtransfer", cfg.FundingMaxTransfer},
+	}
+	for _, amount := range amounts {
+		if amount.value < 0.0 {
+			invalid("%s must not be negative (got %v)", amount.name,
+				amount.value)
+			continue
+		}
+		if _, err := dcrutil.NewAmount(amount.value); err != nil {
+			invalid("%s is not a valid amount (got %v): %v", amount.name,
+				amount.value, err)
+		}
+	}
+	if cfg.MinFee > cfg.MaxFee {
+		invalid("minfee (%v) must not be greater than maxfee (%v)",
+			cfg.MinFee, cfg.MaxFee)
+	}
+
+	// Price scaling.
+	if cfg.MaxPriceScale != 0.0 && cfg.MaxPriceScale <= 1.0 {
+		invalid("maxpricescale must be greater than 1.0 or 0.0 to "+
+			"disable (got %v)", cfg.MaxPriceScale)
+	}
+	if cfg.MinPriceScale != 0.0 &&
+		(cfg.MinPriceScale < 0.0 || cfg.MinPriceScale >= 1.0) {
+		invalid("minpricescale must be between 0.0 and 1.0, or 0.0 to "+
+			"disable (got %v)", cfg.MinPriceScale)
+	}
+	if cfg.MaxPriceScale > 0.0 && cfg.MinPriceScale > 0.0 &&
+		cfg.MinPriceScale >= cfg.MaxPriceScale {
+		invalid("minpricescale (%v) must be less than maxpricescale (%v)",
+			cfg.MinPriceScale, cfg.MaxPriceScale)
+	}
+	if cfg.HighPricePenalty < 0.0 {
+		invalid("highpricepenalty must not be negative (got %v)",
+			cfg.HighPricePenalty)
+	}
+
+	// Window queue.
+	switch cfg.QueueStrategy {
+	case queuePenaltyStr:
+	case queueLadderStr:
+		if cfg.PriceLadder == "" {
+			invalid("the ladder queue strategy is selected but no " +
+				"priceladder is set")
+		} else if _, err := parsePriceLadder(cfg.PriceLadder); err != nil {
+			invalid("priceladder is invalid: %v", err)
+		}
+	case queueTargetStr:
+		if cfg.TargetTickets < 1 {
+			invalid("the target queue strategy is selected but " +
+				"targettickets is unset or 0")
+		}
+		if cfg.TargetWindows < 1 {
+			invalid("targetwindows must be positive (got %v)",
+				cfg.TargetWindows)
+		}
+	default:
+		invalid("queuestrategy must be penalty, ladder or target (got %v)",
+			cfg.QueueStrategy)
+	}
+
+	// Budget.
+	switch cfg.BudgetMode {
+	case budgetNoneStr:
+	case budgetPercentStr:
+		if cfg.BudgetPercent <= 0.0 || cfg.BudgetPercent > 100.0 {
+			invalid("budgetpercent must be greater than 0 and at most 100 "+
+				"(got %v)", cfg.BudgetPercent)
+		}
+	case budgetFixedStr, budgetDCAStr:
+		if cfg.BudgetAmount <= 0.0 {
+			invalid("the %v budget mode is selected but budgetamount is "+
+				"unset or 0.0", cfg.BudgetMode)
+		}
+		if cfg.BudgetMode == budgetDCAStr && cfg.BudgetWindows < 1 {
+			invalid("budgetwindows must be positive (got %v)",
+				cfg.BudgetWindows)
+		}
+	default:
+		invalid("budgetmode must be none, percent, fixed or dca (got %v)",
+			cfg.BudgetMode)
+	}
+
+	if cfg.FundingAccount != "" && cfg.FundingAccount == cfg.AccountName {
+		invalid("fundingaccount must not be the purchasing account %v",
+			cfg.AccountName)
+	}
+
+	// Fees.
+	if cfg.FeeSource != "mean" && cfg.FeeSource != useMedianStr {
+		invalid("feesource must be mean or median (got %v)", cfg.FeeSource)
+	}
+	winSize := int(activeNet.StakeDiffWindowSize)
+	if cfg.BlocksToAvg < 1 || cfg.BlocksToAvg > winSize {
+		invalid("blockstoavg must be between 1 and the stake difficulty "+
+			"window size of %v blocks (got %v)", winSize, cfg.BlocksToAvg)
+	}
+	if cfg.FeeTargetScaling <= 0.0 {
+		invalid("feetargetscaling must be positive (got %v)",
+			cfg.FeeTargetScaling)
+	}
+
+	// Purchasing.
+	if cfg.MaxInMempool < 0 {
+		invalid("maxinmempool must not be negative (got %v)",
+			cfg.MaxInMempool)
+	}
+	if cfg.MaxOutstanding < 0 {
+		invalid("maxoutstanding must not be negative (got %v)",
+			cfg.MaxOutstanding)
+	}
+	if cfg.Consolidate &&
+		(cfg.ConsolidateBlocks < 1 || cfg.ConsolidateBlocks >= winSize) {
+		invalid("consolidateblocks must be between 1 and %v (got %v)",
+			winSize-1, cfg.ConsolidateBlocks)
+	}
+	if cfg.PreSplitOutputs < 0 {
+		invalid("presplitoutputs must not be negative (got %v)",
+			cfg.PreSplitOutputs)
+	}
+	if cfg.ExpiryDelta < 0 {
+		invalid("expirydelta must not be negative (got %v)",
+			cfg.ExpiryDelta)
+	}
+	switch cfg.ExpiryMode {
+	case expiryFixedStr, expiryWindowStr, expiryDynamicStr:
+	default:
+		invalid("expirymode must be fixed, window or dynamic (got %v)",
+			cfg.ExpiryMode)
+	}
+	if cfg.AutoUnlock && cfg.UnlockTimeout < 1 {
+		invalid("unlocktimeout must be at least 1 second (got %v)",
+			cfg.UnlockTimeout)
+	}
+
+	// Addresses and pool fees.
+	if cfg.TicketAddress != "" {
+		_, err := dcrutil.DecodeAddress(cfg.TicketAddress, activeNet.Params)
+		if err != nil {
+			invalid("ticketaddress %v is not a valid %v address: %v",
+				cfg.TicketAddress, activeNet.Name, err)
+		}
+	}
+	if cfg.PoolAddress != "" {
+		_, err := dcrutil.DecodeNetworkAddress(cfg.PoolAddress)
+		if err != nil {
+			invalid("pooladdress %v is not a valid address: %v",
+				cfg.PoolAddress, err)
+		}
+		if cfg.PoolFees == 0.0 {
+			invalid("pooladdress is set but poolfees are unset or 0.00%%")
+		}
+	}
+	if cfg.PoolFees != 0.0 &&
+		(cfg.PoolFees < minPoolFees || cfg.PoolFees > maxPoolFees) {
+		invalid("poolfees must be between %v and %v%% (got %v)",
+			minPoolFees, maxPoolFees, cfg.PoolFees)
+	}
+
+	// Watch-only mode.
+	if cfg.WatchOnly && len(cfg.WatchAddresses) == 0 {
+		invalid("watch-only mode is selected but no watchaddress or " +
+			"ticketaddress is set")
+	}
+	for _, addr := range cfg.WatchAddresses {
+		_, err := dcrutil.DecodeAddress(addr, activeNet.Params)
+		if err != nil {
+			invalid("watchaddress %v is not a valid %v address: %v", addr,
+				activeNet.Name, err)
+		}
+	}
+	if cfg.WatchFromHeight < 0 {
+		invalid("watchfromheight must not be negative (got %v)",
+			cfg.WatchFromHeight)
+	}
+
+	// Stake difficulty and average price models.
+	switch cfg.StakeDiffSource {
+	case "dcrd", useLocalStakeDiffStr:
+	default:
+		invalid("stakediffsource must be dcrd or local (got %v)",
+			cfg.StakeDiffSource)
+	}
+	switch cfg.AvgPriceMode {
+	case avgPriceVWAPStr, avgPricePoolStr, avgPriceDualStr,
+		avgPriceMedianStr, avgPriceFileStr:
+	default:
+		invalid("avgpricemode must be vwap, pool, dual, median or file "+
+			"(got %v)", cfg.AvgPriceMode)
+	}
+	if cfg.AvgPriceVWAPDelta < 0 {
+		invalid("avgpricevwapdelta must not be negative (got %v)",
+			cfg.AvgPriceVWAPDelta)
+	}
+	if cfg.AvgPriceVWAPWeight < 0.0 || cfg.AvgPricePoolWeight < 0.0 {
+		invalid("avgpricevwapweight and avgpricepoolweight must not be "+
+			"negative (got %v and %v)", cfg.AvgPriceVWAPWeight,
+			cfg.AvgPricePoolWeight)
+	} else if cfg.AvgPriceMode == avgPriceDualStr &&
+		cfg.AvgPriceVWAPWeight+cfg.AvgPricePoolWeight == 0.0 {
+		invalid("avgpricevwapweight and avgpricepoolweight can not both " +
+			"be 0.0 in the dual average price model")
+	}
+	if cfg.AvgPriceWindows < 1 {
+		invalid("avgpricewindows must be positive (got %v)",
+			cfg.AvgPriceWindows)
+	}
+	if cfg.AvgPriceMode == avgPriceFileStr && cfg.AvgPriceFile == "" {
+		invalid("the file average price model is selected but no " +
+			"avgpricefile is set")
+	}
+
+	// Pacing.
+	switch cfg.PurchasePacing {
+	case pacingGreedyStr, pacingEvenStr:
+	default:
+		invalid("purchasepacing must be greedy or even (got %v)",
+			cfg.PurchasePacing)
+	}
+	if cfg.PacingJitter < 0.0 || cfg.PacingJitter > 1.0 {
+		invalid("pacingjitter must be between 0.0 and 1.0 (got %v)",
+			cfg.PacingJitter)
+	}
+
+	// Control server.
+	if cfg.ControlListen != "" && cfg.ControlToken == "" {
+		invalid("controllisten is set but no controltoken is set")
+	}
+
+	// Split tickets.
+	switch cfg.SplitMode {
+	case splitNoneStr, splitCoordinatorStr:
+	case splitParticipantStr:
+		if cfg.SplitAmount <= 0.0 {
+			invalid("split ticket participant mode is selected but " +
+				"splitamount is unset or 0.0")
+		}
+	default:
+		invalid("splitmode must be none, coordinator or participant "+
+			"(got %v)", cfg.SplitMode)
+	}
+
+	return errs
+}
+
+// configSources returns the source of the effective value of each setting,
+// keyed by its long option name. A setting is attributed to the command
+// line if it differs from its default in flagCfg, which holds only the
+// defaults and the command line options, and to the config file if it
+// differs from its default in fileCfg, which holds only the defaults and
+// the config file. A setting given the value of its default is reported
+// as the default.
+func configSources(defaultCfg, fileCfg, flagCfg *config) map[string]string {
+	sources := make(map[string]string)
+	defaults := reflect.ValueOf(defaultCfg).Elem()
+	file := reflect.ValueOf(fileCfg).Elem()
+	flag := reflect.ValueOf(flagCfg).Elem()
+	for i := 0; i < defaults.NumField(); i++ {
+		name := defaults.Type().Field(i).Tag.Get("long")
+		if name == "" {
+			continue
+		}
+		def := defaults.Field(i).Interface()
+		switch {
+		case !reflect.DeepEqual(flag.Field(i).Interface(), def):
+			sources[name] = configSourceFlag
+		case !reflect.DeepEqual(file.Field(i).Interface(), def):
+			sources[name] = configSourceFile
+		default:
+			sources[name] = configSourceDefault
+		}
+	}
+	return sources
+}
+
+// printConfig writes every effective setting with its source. Passwords
+// and the control token are masked.
+func printConfig(w io.Writer, cfg *config, sources map[string]string) {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Tag.Get("long")
+		if name == "" {
+			continue
+		}
+		value := fmt.Sprint(v.Field(i).Interface())
+		if (strings.HasSuffix(name, "pass") || name == "controltoken") &&
+			value != "" {
+			value = "********"
+		}
+		fmt.Fprintf(w, "%-20s = %-30s (%s)\n", name, value, sources[name])
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestValidateConfig ensures that the default configuration is valid and
+// that each setting that is out of range or contradicts another setting is
+// reported with an error naming it.
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *config)
+		wantErr   string
+	}{
+		{
+			name:      "defaults",
+			configure: func(cfg *config) {},
+		},
+		{
+			name:      "negative amount",
+			configure: func(cfg *config) { cfg.BalanceToMaintain = -1.0 },
+			wantErr:   "balancetomaintain",
+		},
+		{
+			name:      "minfee above maxfee",
+			configure: func(cfg *config) { cfg.MinFee = 2.0 },
+			wantErr:   "minfee",
+		},
+		{
+			name:      "maxpricescale at 1.0",
+			configure: func(cfg *config) { cfg.MaxPriceScale = 1.0 },
+			wantErr:   "maxpricescale",
+		},
+		{
+			name:      "minpricescale at 1.0",
+			configure: func(cfg *config) { cfg.MinPriceScale = 1.0 },
+			wantErr:   "minpricescale",
+		},
+		{
+			name: "ladder without priceladder",
+			configure: func(cfg *config) {
+				cfg.QueueStrategy = queueLadderStr
+			},
+			wantErr: "priceladder",
+		},
+		{
+			name: "valid ladder",
+			configure: func(cfg *config) {
+				cfg.QueueStrategy = queueLadderStr
+				cfg.PriceLadder = "0-80:100,80-100:50,100-:0"
+			},
+		},
+		{
+			name: "invalid ladder",
+			configure: func(cfg *config) {
+				cfg.QueueStrategy = queueLadderStr
+				cfg.PriceLadder = "0-80:50,80-:100"
+			},
+			wantErr: "priceladder",
+		},
+		{
+			name: "target without targettickets",
+			configure: func(cfg *config) {
+				cfg.QueueStrategy = queueTargetStr
+				cfg.TargetTickets = 0
+			},
+			wantErr: "targettickets",
+		},
+		{
+			name:      "unknown queue strategy",
+			configure: func(cfg *config) { cfg.QueueStrategy = "all" },
+			wantErr:   "queuestrategy",
+		},
+		{
+			name: "budgetpercent above 100",
+			configure: func(cfg *config) {
+				cfg.BudgetMode = budgetPercentStr
+				cfg.BudgetPercent = 101.0
+			},
+			wantErr: "budgetpercent",
+		},
+		{
+			name: "dca without budgetamount",
+			configure: func(cfg *config) {
+				cfg.BudgetMode = budgetDCAStr
+				cfg.BudgetAmount = 0.0
+			},
+			wantErr: "budgetamount",
+		},
+		{
+			name: "funding from the purchasing account",
+			configure: func(cfg *config) {
+				cfg.FundingAccount = cfg.AccountName
+			},
+			wantErr: "fundingaccount",
+		},
+		{
+			name:      "unknown fee source",
+			configure: func(cfg *config) { cfg.FeeSource = "max" },
+			wantErr:   "feesource",
+		},
+		{
+			name: "blockstoavg above the window size",
+			configure: func(cfg *config) {
+				cfg.BlocksToAvg = int(activeNet.StakeDiffWindowSize) + 1
+			},
+			wantErr: "blockstoavg",
+		},
+		{
+			name: "consolidateblocks of a whole window",
+			configure: func(cfg *config) {
+				cfg.Consolidate = true
+				cfg.ConsolidateBlocks = int(activeNet.StakeDiffWindowSize)
+			},
+			wantErr: "consolidateblocks",
+		},
+		{
+			name:      "unknown expiry mode",
+			configure: func(cfg *config) { cfg.ExpiryMode = "never" },
+			wantErr:   "expirymode",
+		},
+		{
+			name:      "invalid ticketaddress",
+			configure: func(cfg *config) { cfg.TicketAddress = "invalid" },
+			wantErr:   "ticketaddress",
+		},
+		{
+			name: "poolfees out of range",
+			configure: func(cfg *config) {
+				cfg.PoolFees = 200.0
+			},
+			wantErr: "poolfees",
+		},
+		{
+			name: "watch-only without addresses",
+			configure: func(cfg *config) {
+				cfg.WatchOnly = true
+			},
+			wantErr: "watchaddress",
+		},
+		{
+			name: "dual average without weights",
+			configure: func(cfg *config) {
+				cfg.AvgPriceMode = avgPriceDualStr
+				cfg.AvgPriceVWAPWeight = 0.0
+				cfg.AvgPricePoolWeight = 0.0
+			},
+			wantErr: "avgpricevwapweight",
+		},
+		{
+			name: "file average without avgpricefile",
+			configure: func(cfg *config) {
+				cfg.AvgPriceMode = avgPriceFileStr
+			},
+			wantErr: "avgpricefile",
+		},
+		{
+			name:      "pacingjitter above 1.0",
+			configure: func(cfg *config) { cfg.PacingJitter = 1.5 },
+			wantErr:   "pacingjitter",
+		},
+		{
+			name: "controllisten without controltoken",
+			configure: func(cfg *config) {
+				cfg.ControlListen = "localhost:9121"
+			},
+			wantErr: "controltoken",
+		},
+		{
+			name: "controllisten with controltoken",
+			configure: func(cfg *config) {
+				cfg.ControlListen = "localhost:9121"
+				cfg.ControlToken = "secret"
+			},
+		},
+		{
+			name: "participant without splitamount",
+			configure: func(cfg *config) {
+				cfg.SplitMode = splitParticipantStr
+			},
+			wantErr: "splitamount",
+		},
+	}
+
+	for _, test := range tests {
+		cfg := defaultConfig()
+		test.configure(&cfg)
+		errs := validateConfig(&cfg)
+		if test.wantErr == "" {
+			if len(errs) != 0 {
+				t.Errorf("%s: unexpected errors: %v", test.name, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 {
+			t.Errorf("%s: got errors %v, want one about %v", test.name,
+				errs, test.wantErr)
+			continue
+		}
+		if !strings.Contains(errs[0].Error(), test.wantErr) {
+			t.Errorf("%s: got error %q, want one about %v", test.name,
+				errs[0], test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrjson"
+	"github.com/decred/dcrd/txscript"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrutil"
+)
+
+const (
+	// consolidateTxOverhead, consolidateInputSize and consolidateOutputSize
+	// are the estimated serialized sizes in bytes of a transaction without
+	// inputs and outputs, of a signed pay-to-pubkey-hash input including
+	// its witness, and of a pay-to-pubkey-hash output. They are used to
+	// estimate the fee of a consolidation transaction.
+	consolidateTxOverhead = 15
+	consolidateInputSize  = 166
+	consolidateOutputSize = 36
+
+	// dustSpendSize is the estimated size in bytes of the input that
+	// spends an output, which the network's dust rule adds to the size of
+	// the output.
+	dustSpendSize = 165
+
+	// maxConsolidateInputs is the maximum number of outputs spent by a
+	// single consolidation transaction, which keeps it well below the
+	// standard transaction size limit.
+	maxConsolidateInputs = 100
+)
+
+// unspentByAmount sorts unspent outputs by increasing amount.
+type unspentByAmount []dcrjson.ListUnspentResult
+
+func (u unspentByAmount) Len() int           { return len(u) }
+func (u unspentByAmount) Less(i, j int) bool { return u[i].Amount < u[j].Amount }
+func (u unspentByAmount) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
+// consolidationFee estimates the fee for a consolidation transaction with
+// the given number of inputs and outputs at feePerKB.
+func consolidationFee(numInputs, numOutputs int,
+	feePerKB dcrutil.Amount) dcrutil.Amount {
+	size := consolidateTxOverhead + numInputs*consolidateInputSize +
+		numOutputs*consolidateOutputSize
+	return dcrutil.Amount(math.Ceil(float64(feePerKB) * float64(size) /
+		1000.0))
+}
+
+// isDustAmount returns whether a pay-to-pubkey-hash output of amt is dust
+// at the relay fee feePerKB, in which case the network will not relay the
+// transaction creating it. This is the same rule the daemon's mempool
+// applies: an output is dust if spending it would cost more than a third
+// of its value in fees.
+func isDustAmount(amt, feePerKB dcrutil.Amount) bool {
+	totalSize := consolidateOutputSize + dustSpendSize
+	return int64(amt)*1000/(3*int64(totalSize)) < int64(feePerKB)
+}
+
+// consolidationOutputs splits the total of the consolidated outputs, less
+// the fee, into as many outputs of size target as possible, with any
+// remainder in one more output. If the total is below target, a single
+// output holds all of it. A remainder that would be dust is added to the
+// last output instead.
+func consolidationOutputs(total, target dcrutil.Amount, numInputs int,
+	feePerKB dcrutil.Amount) []dcrutil.Amount {
+	n := int(total / target)
+	if n == 0 {
+		n = 1
+	}
+	for n > 0 {
+		fee := consolidationFee(numInputs, n+1, feePerKB)
+		remainder := total - fee - dcrutil.Amount(n)*target
+		if remainder >= 0 {
+			outputs := make([]dcrutil.Amount, n, n+1)
+			for i := range outputs {
+				outputs[i] = target
+			}
+			if !isDustAmount(remainder, feePerKB) {
+				outputs = append(outputs, remainder)
+			} else {
+				// Without the remainder output, the fee is smaller
+				// and the remainder only grows.
+				outputs[n-1] += total -
+					consolidationFee(numInputs, n, feePerKB) -
+					dcrutil.Amount(n)*target
+			}
+			return outputs
+		}
+		n--
+	}
+
+	fee := consolidationFee(numInputs, 1, feePerKB)
+	if total <= fee || isDustAmount(total-fee, feePerKB) {
+		return nil
+	}
+	return []dcrutil.Amount{total - fee}
+}
+
+// consolidationTarget returns the size of the outputs to consolidate into,
+// which is the expected stake difficulty of the next window plus the
+// estimated fee of a ticket.
+func (t *ticketPurchaser) consolidationTarget() (dcrutil.Amount, error) {
+	price := 0.0
+	if t.lastResult != nil {
+		price = t.lastResult.EstimateExpected
+		if price <= 0.0 {
+			price = t.lastResult.NextStakeDiff
+		}
+	}
+	if price <= 0.0 {
+		stakeDiffs, err := t.dcrdChainSvr.GetStakeDifficulty()
+		if err != nil {
+			return 0, err
+		}
+		price = stakeDiffs.NextStakeDifficulty
+	}
+	priceAmt, err := dcrutil.NewAmount(price)
+	if err != nil {
+		return 0, err
+	}
+	ticketFee, err := t.estimatedTicketFee()
+	if err != nil {
+		return 0, err
+	}
+	return priceAmt + ticketFee, nil
+}
+
+// consolidate combines the small confirmed outputs of the account into
+// outputs sized near the expected stake difficulty of the next window,
+// once per window in the last consolidateblocks blocks before the next
+// window's purchases begin, so that those purchases neither fail because
+// the balance is fragmented nor pay the fees for many inputs.
+func (t *ticketPurchaser) consolidate(height int32) error {
+	if !t.cfg.Consolidate {
+		return nil
+	}
+	winSize := int32(activeNet.StakeDiffWindowSize)
+	idx := int(height % winSize)
+	period := int(height / winSize)
+	if idx == int(winSize)-1 ||
+		blocksLeftInWindow(idx) > t.cfg.ConsolidateBlocks ||
+		period == t.consolidatedWindow {
+		return nil
+	}
+	t.consolidatedWindow = period
+
+	target, err := t.consolidationTarget()
+	if err != nil {
+		return err
+	}
+	feePerKB, err := dcrutil.NewAmount(t.cfg.TxFee)
+	if err != nil {
+		return err
+	}
+
+	// Collect the outputs smaller than a ticket, smallest first.
+	unspent, err := t.dcrwChainSvr.ListUnspentMin(1)
+	if err != nil {
+		return err
+	}
+	var small []dcrjson.ListUnspentResult
+	for _, u := range unspent {
+		if u.Account != t.cfg.AccountName || !u.Spendable {
+			continue
+		}
+		amt, err := dcrutil.NewAmount(u.Amount)
+		if err != nil {
+			return err
+		}
+		if amt < target {
+			small = append(small, u)
+		}
+	}
+	if len(small) < 2 {
+		log.Tracef("Not consolidating at height %v: %v %s smaller than %v",
+			height, len(small), pickNoun(len(small), "output",
+				"outputs"), target)
+		return nil
+	}
+	sort.Sort(unspentByAmount(small))
+	if len(small) > maxConsolidateInputs {
+		small = small[:maxConsolidateInputs]
+	}
+
+	mtx := wire.NewMsgTx()
+	var total dcrutil.Amount
+	for _, u := range small {
+		hash, err := chainhash.NewHashFromStr(u.TxID)
+		if err != nil {
+			return err
+		}
+		amt, err := dcrutil.NewAmount(u.Amount)
+		if err != nil {
+			return err
+		}
+		txIn := wire.NewTxIn(wire.NewOutPoint(hash, u.Vout, u.Tree), nil)
+		txIn.ValueIn = int64(amt)
+		mtx.AddTxIn(txIn)
+		total += amt
+	}
+
+	outputs := consolidationOutputs(total, target, len(small), feePerKB)
+	if len(outputs) == 0 {
+		log.Debugf("Not consolidating at height %v: the %v outputs "+
+			"totalling %v do not cover the fee", height, len(small), total)
+		return nil
+	}
+	for _, amt := range outputs {
+		addr, err := t.dcrwChainSvr.GetRawChangeAddress(t.cfg.AccountName)
+		if err != nil {
+			return err
+		}
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return err
+		}
+		mtx.AddTxOut(wire.NewTxOut(int64(amt), pkScript))
+	}
+
+	var signedTx *wire.MsgTx
+	var complete bool
+	err = t.withUnlockedWallet(func() error {
+		var err error
+		signedTx, complete, err = t.dcrwChainSvr.SignRawTransaction(mtx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !complete {
+		return purchaseErrorf(ErrUnknown, "The wallet could not sign "+
+			"every input of the consolidation transaction")
+	}
+	txHash, err := t.dcrdChainSvr.SendRawTransaction(signedTx, false)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Consolidated %v outputs totalling %v into %v %s of about "+
+		"%v in transaction %v", len(small), total, len(outputs),
+		pickNoun(len(outputs), "output", "outputs"), target, txHash)
+
+	return nil
+}
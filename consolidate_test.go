@@ -0,0 +1,95 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/decred/dcrutil"
+)
+
+// TestIsDustAmount ensures outputs are dust below three times the relay fee
+// of the output and the input spending it.
+func TestIsDustAmount(t *testing.T) {
+	tests := []struct {
+		amt      dcrutil.Amount
+		feePerKB dcrutil.Amount
+		want     bool
+	}{
+		{0, 1e6, true},
+		{602999, 1e6, true},
+		{603000, 1e6, false},
+		{1e8, 1e6, false},
+		{60299, 1e5, true},
+		{60300, 1e5, false},
+	}
+
+	for _, test := range tests {
+		got := isDustAmount(test.amt, test.feePerKB)
+		if got != test.want {
+			t.Errorf("%v at %v per KB: got %v, want %v", test.amt,
+				test.feePerKB, got, test.want)
+		}
+	}
+}
+
+// TestConsolidationOutputs ensures the consolidated total is split into
+// outputs of the target size less the fee, with a remainder output only if
+// it is not dust. The fees at 0.01 coins per KB for three inputs are
+// 549000 atoms with one output, 585000 with two and 621000 with three.
+func TestConsolidationOutputs(t *testing.T) {
+	const (
+		target   = dcrutil.Amount(10e8)
+		feePerKB = dcrutil.Amount(1e6)
+	)
+	tests := []struct {
+		name  string
+		total dcrutil.Amount
+		want  []dcrutil.Amount
+	}{
+		{
+			name:  "below target",
+			total: 6e8,
+			want:  []dcrutil.Amount{6e8 - 549000},
+		},
+		{
+			name:  "remainder output",
+			total: 25e8,
+			want:  []dcrutil.Amount{target, target, 5e8 - 621000},
+		},
+		{
+			name:  "remainder at the dust limit",
+			total: 20e8 + 621000 + 603000,
+			want:  []dcrutil.Amount{target, target, 603000},
+		},
+		{
+			name:  "dust remainder added to the last output",
+			total: 20e8 + 621000 + 100000,
+			want:  []dcrutil.Amount{target, target + 621000 + 100000 - 585000},
+		},
+		{
+			name:  "second output does not cover its fee",
+			total: 20e8 + 600000,
+			want:  []dcrutil.Amount{target, target + 600000 - 585000},
+		},
+		{
+			name:  "total below the fee",
+			total: 500000,
+		},
+		{
+			name:  "dust after the fee",
+			total: 549000 + 602999,
+		},
+	}
+
+	for _, test := range tests {
+		got := consolidationOutputs(test.total, target, 3, feePerKB)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got outputs %v, want %v", test.name, got,
+				test.want)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrutil"
+)
+
+// controlTokenHeader is the HTTP header that carries the control token.
+const controlTokenHeader = "X-Control-Token"
+
+// controlPurchaseRequest requests the immediate purchase of tickets from
+// the running ticket buyer. A zero maximum price means that only the
+// configured maxpriceabsolute applies.
+type controlPurchaseRequest struct {
+	Count    int     `json:"count"`
+	MaxPrice float64 `json:"maxprice"`
+}
+
+// controlPurchaseResponse is the result of a manual purchase.
+type controlPurchaseResponse struct {
+	Tickets []string `json:"tickets"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// controlHistoryRequest requests the values stored in a bucket of the
+// history from one height to another, inclusive.
+type controlHistoryRequest struct {
+	Bucket string `json:"bucket"`
+	From   int32  `json:"from"`
+	To     int32  `json:"to"`
+}
+
+// controlHistoryResponse holds the JSON encoded values stored in a bucket
+// of the history, in order of height.
+type controlHistoryResponse struct {
+	Values []json.RawMessage `json:"values"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// controlRoundRequest requests the stored decision of the purchase round at
+// a height, or of the latest round if the height is negative.
+type controlRoundRequest struct {
+	Height int32 `json:"height"`
+}
+
+// controlRoundResponse holds the stored decision of a purchase round, which
+// is nil if there is none.
+type controlRoundResponse struct {
+	Round *purchaseResult `json:"round"`
+	Error string          `json:"error,omitempty"`
+}
+
+// controlUnreachableError is returned when the control server of the
+// running ticket buyer can not be reached.
+type controlUnreachableError struct {
+	err error
+}
+
+// Error satisfies the error interface.
+func (e controlUnreachableError) Error() string {
+	return fmt.Sprintf("failed to reach the running ticket buyer: %v",
+		e.err)
+}
+
+// manualPurchase is a manual purchase request passed to the purchase
+// manager, so that it is serialized with the purchase rounds.
+type manualPurchase struct {
+	request *controlPurchaseRequest
+	reply   chan *controlPurchaseResponse
+}
+
+// controlServer serves commands from the command line to the running
+// ticket buyer over local HTTP. Commands must be JSON POST requests that
+// present the control token. The history is read through it, since the
+// running ticket buyer keeps the history database locked.
+type controlServer struct {
+	listen       string
+	token        string
+	purchaseChan chan *manualPurchase
+	history      *history
+	quit         chan struct{}
+}
+
+// newControlServer creates a new controlServer. Purchase requests fail
+// once quit is closed, since the purchase manager no longer handles them.
+// The history may be nil if it is disabled.
+func newControlServer(listen, token string, purchaseChan chan *manualPurchase,
+	history *history, quit chan struct{}) *controlServer {
+	return &controlServer{
+		listen:       listen,
+		token:        token,
+		purchaseChan: purchaseChan,
+		history:      history,
+		quit:         quit,
+	}
+}
+
+// handler returns the handler serving control commands.
+func (c *controlServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/purchase", c.handlePurchase)
+	mux.HandleFunc("/history", c.handleHistory)
+	mux.HandleFunc("/round", c.handleRound)
+	return mux
+}
+
+// start begins serving control commands.
+func (c *controlServer) start() {
+	mux := c.handler()
+	go func() {
+		log.Infof("Control server listening on %v", c.listen)
+		err := http.ListenAndServe(c.listen, mux)
+		if err != nil {
+			log.Errorf("Control server failed: %v", err)
+		}
+	}()
+}
+
+// authorize checks that a request is a JSON POST request that presents
+// the control token, writing an error response if it is not.
+func (c *controlServer) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		http.Error(w, "content type must be application/json",
+			http.StatusUnsupportedMediaType)
+		return false
+	}
+	token := r.Header.Get(controlTokenHeader)
+	if c.token == "" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) != 1 {
+		http.Error(w, "invalid control token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handlePurchase passes a manual purchase request to the purchase manager
+// and waits for the result.
+func (c *controlServer) handlePurchase(w http.ResponseWriter, r *http.Request) {
+	if !c.authorize(w, r) {
+		return
+	}
+	var req controlPurchaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, &controlPurchaseResponse{Error: err.Error()})
+		return
+	}
+
+	mp := &manualPurchase{
+		request: &req,
+		reply:   make(chan *controlPurchaseResponse, 1),
+	}
+	select {
+	case c.purchaseChan <- mp:
+	case <-c.quit:
+		writeJSON(w, &controlPurchaseResponse{
+			Error: "the ticket buyer is shutting down",
+		})
+		return
+	}
+	writeJSON(w, <-mp.reply)
+}
+
+// handleHistory returns the values stored in the blocks or rounds bucket of
+// the history in a range of heights.
+func (c *controlServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if !c.authorize(w, r) {
+		return
+	}
+	var req controlHistoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, &controlHistoryResponse{Error: err.Error()})
+		return
+	}
+	if c.history == nil {
+		writeJSON(w, &controlHistoryResponse{
+			Error: "the history database is disabled",
+		})
+		return
+	}
+	var bucket []byte
+	switch req.Bucket {
+	case string(blocksBucketName):
+		bucket = blocksBucketName
+	case string(roundsBucketName):
+		bucket = roundsBucketName
+	default:
+		writeJSON(w, &controlHistoryResponse{
+			Error: fmt.Sprintf("unknown history bucket %v", req.Bucket),
+		})
+		return
+	}
+
+	resp := &controlHistoryResponse{Values: []json.RawMessage{}}
+	err := c.history.forEach(bucket, req.From, req.To, func(v []byte) error {
+		resp.Values = append(resp.Values, append(json.RawMessage(nil),
+			v...))
+		return nil
+	})
+	if err != nil {
+		writeJSON(w, &controlHistoryResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// handleRound returns the stored decision of a purchase round.
+func (c *controlServer) handleRound(w http.ResponseWriter, r *http.Request) {
+	if !c.authorize(w, r) {
+		return
+	}
+	var req controlRoundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, &controlRoundResponse{Error: err.Error()})
+		return
+	}
+	if c.history == nil {
+		writeJSON(w, &controlRoundResponse{
+			Error: "the history database is disabled",
+		})
+		return
+	}
+	result, err := c.history.round(req.Height)
+	if err != nil {
+		writeJSON(w, &controlRoundResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, &controlRoundResponse{Round: result})
+}
+
+// manualPurchase immediately purchases up to count tickets outside of the
+// window queue, using the last computed ticket fee, the configured ticket
+// and pool addresses and the same safety checks as a purchase round,
+// including the caps on outstanding tickets and locked coins. The
+// tickets are recorded as purchased in the current window and their cost
+// is counted against the dollar-cost averaging budget.
+func (t *ticketPurchaser) manualPurchase(count int,
+	maxPrice float64) ([]string, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("the number of tickets to purchase must " +
+			"be positive")
+	}
+
+	walletInfo, err := t.dcrwChainSvr.WalletInfo()
+	if err != nil {
+		return nil, err
+	}
+	if !walletInfo.DaemonConnected {
+		return nil, purchaseErrorf(ErrWalletNotConnected,
+			"Wallet not connected to daemon")
+	}
+	if !walletInfo.Unlocked && t.walletPass == nil {
+		return nil, purchaseErrorf(ErrWalletLocked,
+			"Wallet not unlocked to allow ticket purchases")
+	}
+
+	// The price ceiling can only lower the absolute maximum price.
+	maxPriceAbsAmt, err := dcrutil.NewAmount(t.cfg.MaxPriceAbsolute)
+	if err != nil {
+		return nil, err
+	}
+	if maxPrice > 0.0 {
+		maxPriceAmt, err := dcrutil.NewAmount(maxPrice)
+		if err != nil {
+			return nil, err
+		}
+		if maxPriceAmt < maxPriceAbsAmt {
+			maxPriceAbsAmt = maxPriceAmt
+		}
+	}
+
+	stakeDiffs, err := t.dcrwChainSvr.GetStakeDifficulty()
+	if err != nil {
+		return nil, err
+	}
+	nextStakeDiff, err := dcrutil.NewAmount(stakeDiffs.NextStakeDifficulty)
+	if err != nil {
+		return nil, err
+	}
+	if nextStakeDiff > maxPriceAbsAmt {
+		return nil, fmt.Errorf("the ticket price %v is higher than the "+
+			"maximum price %v", nextStakeDiff, maxPriceAbsAmt)
+	}
+
+	// Purchase fewer tickets if buying all of them would take the
+	// balance below the balance to maintain.
+	balSpendable, err := t.dcrwChainSvr.GetBalanceMinConfType(t.cfg.AccountName,
+		0, "spendable")
+	if err != nil {
+		return nil, err
+	}
+	for count > 0 && balSpendable.ToCoin()-float64(count)*
+		nextStakeDiff.ToCoin() < t.cfg.BalanceToMaintain {
+		count--
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("the spendable balance %v is too low to "+
+			"purchase a ticket at %v while maintaining a balance of %v",
+			balSpendable, nextStakeDiff, t.cfg.BalanceToMaintain)
+	}
+
+	// Stay within the caps on outstanding tickets and coins locked in
+	// tickets.
+	room, capReason, err := t.outstandingRoom(nextStakeDiff)
+	if err != nil {
+		return nil, err
+	}
+	if room >= 0 && room < count {
+		if room == 0 {
+			return nil, fmt.Errorf("no more tickets may be purchased "+
+				"(%v)", capReason)
+		}
+		count = room
+	}
+
+	// Use the fee computed in the last purchase round, or the minimum
+	// fee if there has not been one yet.
+	if t.ticketFee == 0 {
+		feeAmt, err := dcrutil.NewAmount(t.cfg.MinFee)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.dcrwChainSvr.SetTicketFee(feeAmt); err != nil {
+			return nil, err
+		}
+		t.ticketFee = feeAmt
+	}
+
+	height, err := t.dcrdChainSvr.GetBlockCount()
+	if err != nil {
+		return nil, err
+	}
+	tickets, err := t.buyTickets(int32(height), count, maxPriceAbsAmt)
+	if err != nil {
+		return nil, err
+	}
+	t.budgetSpent(len(tickets), nextStakeDiff)
+
+	hashes := make([]string, len(tickets))
+	for i := range tickets {
+		hashes[i] = tickets[i].String()
+		log.Infof("Manually purchased ticket %v at stake difficulty %v "+
+			"(%v fees per KB used)", tickets[i], nextStakeDiff.ToCoin(),
+			t.ticketFee.ToCoin())
+	}
+
+	return hashes, nil
+}
+
+// runBuyCommand asks the running ticket buyer to purchase tickets
+// immediately. The arguments are the number of tickets and an optional
+// price ceiling in coins.
+func runBuyCommand(cfg *config, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: buy <count> [maxprice]")
+	}
+	req := &controlPurchaseRequest{}
+	var err error
+	req.Count, err = strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid ticket count %v: %v", args[0], err)
+	}
+	if len(args) == 2 {
+		req.MaxPrice, err = strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return fmt.Errorf("invalid maximum price %v: %v", args[1], err)
+		}
+	}
+	if cfg.ControlListen == "" {
+		return fmt.Errorf("the control server address is not set")
+	}
+
+	var resp controlPurchaseResponse
+	if err := postControl(cfg, "/purchase", req, &resp); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("purchase failed: %v", resp.Error)
+	}
+
+	for _, ticket := range resp.Tickets {
+		fmt.Println(ticket)
+	}
+	fmt.Printf("Purchased %v %s\n", len(resp.Tickets),
+		pickNoun(len(resp.Tickets), "ticket", "tickets"))
+
+	return nil
+}
+
+// postControl sends a command to the control server of the running ticket
+// buyer and decodes its response. A controlUnreachableError is returned if
+// the control server can not be reached.
+func postControl(cfg *config, path string, req, resp interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost,
+		"http://"+cfg.ControlListen+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(controlTokenHeader, cfg.ControlToken)
+	r, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return controlUnreachableError{err}
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(r.Body)
+		return fmt.Errorf("the running ticket buyer refused the "+
+			"request: %v", strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+// controlHistory reads the history through the control server of the
+// running ticket buyer.
+type controlHistory struct {
+	cfg *config
+}
+
+// forEach calls f with the JSON encoded value of every height from from
+// to to, inclusive, stored in a bucket, in order of height.
+func (h *controlHistory) forEach(bucket []byte, from, to int32,
+	f func(v []byte) error) error {
+	var resp controlHistoryResponse
+	err := postControl(h.cfg, "/history", &controlHistoryRequest{
+		Bucket: string(bucket),
+		From:   from,
+		To:     to,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("failed to read the history: %v", resp.Error)
+	}
+	for _, v := range resp.Values {
+		if err := f(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// round returns the stored decision of the purchase round at height, or
+// of the latest round if height is negative, or nil if there is none.
+func (h *controlHistory) round(height int32) (*purchaseResult, error) {
+	var resp controlRoundResponse
+	err := postControl(h.cfg, "/round", &controlRoundRequest{Height: height},
+		&resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("failed to read the history: %v",
+			resp.Error)
+	}
+	return resp.Round, nil
+}
+
+// readHistory calls f with a reader of the history. The history is read
+// through the control server if one is configured and the ticket buyer is
+// running, since the running ticket buyer keeps the history database
+// locked, and from the history database otherwise.
+func readHistory(cfg *config, f func(historyReader) error) error {
+	if cfg.ControlListen != "" {
+		err := f(&controlHistory{cfg})
+		if _, ok := err.(controlUnreachableError); !ok {
+			return err
+		}
+		log.Debugf("Reading the history database directly: %v", err)
+	}
+
+	h, err := openHistory(historyFile(cfg), true)
+	if err != nil {
+		return err
+	}
+	defer h.close()
+	return f(h)
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cjepson/dcrticketbuyer/rpctest"
+	"github.com/decred/dcrd/dcrjson"
+	"github.com/decred/dcrutil"
+)
+
+// TestControlHistory ensures the history of a running ticket buyer, which
+// keeps the history database locked, is read through its control server,
+// and that requests without the control token or for other buckets are
+// refused.
+func TestControlHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := defaultConfig()
+	cfg.DataDir = dir
+
+	h, err := openHistory(historyFile(&cfg), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.close()
+	for height := int32(1); height <= 3; height++ {
+		if err := h.putBlock(&blockObservation{Height: height}); err != nil {
+			t.Fatal(err)
+		}
+		err := h.putRound(&purchaseResult{
+			Height:        height,
+			TicketsBought: int(height),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := httptest.NewServer(newControlServer("", "secret", nil, h,
+		nil).handler())
+	defer srv.Close()
+	cfg.ControlListen = strings.TrimPrefix(srv.URL, "http://")
+	cfg.ControlToken = "secret"
+
+	var latest *purchaseResult
+	var heights []int32
+	err = readHistory(&cfg, func(r historyReader) error {
+		var err error
+		latest, err = r.round(-1)
+		if err != nil {
+			return err
+		}
+		return r.forEach(roundsBucketName, 2, 3, func(v []byte) error {
+			var result purchaseResult
+			if err := json.Unmarshal(v, &result); err != nil {
+				return err
+			}
+			heights = append(heights, result.Height)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("failed to read the history: %v", err)
+	}
+	if latest == nil || latest.Height != 3 || latest.TicketsBought != 3 {
+		t.Errorf("got latest round %+v, want the round at height 3", latest)
+	}
+	if !reflect.DeepEqual(heights, []int32{2, 3}) {
+		t.Errorf("got rounds at heights %v, want 2 and 3", heights)
+	}
+
+	var resp controlHistoryResponse
+	err = postControl(&cfg, "/history", &controlHistoryRequest{
+		Bucket: string(stateBucketName),
+	}, &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == "" {
+		t.Errorf("read the state bucket, want an error")
+	}
+
+	cfg.ControlToken = "wrong"
+	err = readHistory(&cfg, func(r historyReader) error {
+		_, err := r.round(-1)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid control token") {
+		t.Errorf("got error %v with the wrong token, want it refused", err)
+	}
+}
+
+// TestManualPurchase ensures that manual purchases go through the same
+// safety checks as a purchase round, that the price ceiling can only lower
+// the maximum price, and that the tickets purchased are counted in the
+// current window and against the dollar-cost averaging budget.
+func TestManualPurchase(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(h *rpctest.Harness, cfg *config)
+		walletPass  []byte
+		count       int
+		maxPrice    float64
+		wantTickets int
+		wantErr     string
+		wantLimit   float64
+	}{
+		{"unlocked", nil, nil, 2, 0, 2, "", defaultMaxPriceAbsolute},
+		{"no tickets", nil, nil, 0, 0, 0, "must be positive", 0},
+		{"locked", func(h *rpctest.Harness, cfg *config) {
+			h.Wallet.SetLocked(true)
+		}, nil, 2, 0, 0, "not unlocked", 0},
+		{"autounlock", func(h *rpctest.Harness, cfg *config) {
+			h.Wallet.SetLocked(true)
+		}, []byte("passphrase"), 2, 0, 2, "", defaultMaxPriceAbsolute},
+		{"not connected", func(h *rpctest.Harness, cfg *config) {
+			h.Wallet.SetDaemonConnected(false)
+		}, nil, 2, 0, 0, "not connected", 0},
+		{"below maxprice", nil, nil, 2, 11, 2, "", 11},
+		{"above maxprice", nil, nil, 2, 9, 0, "higher than the maximum", 0},
+		{"maxprice above maxpriceabsolute", func(h *rpctest.Harness,
+			cfg *config) {
+			cfg.MaxPriceAbsolute = 12
+		}, nil, 2, 50, 2, "", 12},
+		{"limited by the balance", func(h *rpctest.Harness, cfg *config) {
+			h.Wallet.SetBalance(25)
+		}, nil, 3, 0, 2, "", defaultMaxPriceAbsolute},
+		{"balance too low", func(h *rpctest.Harness, cfg *config) {
+			cfg.BalanceToMaintain = 95
+		}, nil, 1, 0, 0, "too low", 0},
+		{"limited by maxoutstanding", func(h *rpctest.Harness,
+			cfg *config) {
+			cfg.MaxOutstanding = 5
+			h.Wallet.SetStakeInfo(dcrjson.GetStakeInfoResult{Live: 4})
+		}, nil, 3, 0, 1, "", defaultMaxPriceAbsolute},
+		{"maxoutstanding reached", func(h *rpctest.Harness, cfg *config) {
+			cfg.MaxOutstanding = 5
+			h.Wallet.SetStakeInfo(dcrjson.GetStakeInfoResult{Live: 5})
+		}, nil, 1, 0, 0, "no more tickets", 0},
+	}
+
+	for _, test := range tests {
+		h := newTestHarness(t, 300)
+		cfg := newTestConfig(h)
+		cfg.BudgetMode = budgetDCAStr
+		cfg.BudgetAmount = 100
+		cfg.BudgetWindows = 4
+		if test.setup != nil {
+			test.setup(h, cfg)
+		}
+		b := startTestBuyer(t, h, cfg, test.walletPass, false)
+		resp := b.purchase(test.count)
+		b.stop()
+
+		if test.wantErr != "" {
+			if !strings.Contains(resp.Error, test.wantErr) {
+				t.Errorf("%s: got error %q, want one containing %q",
+					test.name, resp.Error, test.wantErr)
+			}
+		} else if resp.Error != "" {
+			t.Errorf("%s: unexpected error: %v", test.name, resp.Error)
+		}
+		if len(resp.Tickets) != test.wantTickets {
+			t.Errorf("%s: got %v tickets, want %v", test.name,
+				len(resp.Tickets), test.wantTickets)
+		}
+
+		purchases := h.Wallet.Purchases()
+		if test.wantTickets == 0 {
+			if len(purchases) != 0 {
+				t.Errorf("%s: got purchases %+v, want none", test.name,
+					purchases)
+			}
+		} else if len(purchases) != 1 ||
+			purchases[0].NumTickets != test.wantTickets ||
+			purchases[0].SpendLimit != test.wantLimit {
+			t.Errorf("%s: got purchases %+v, want one of %v tickets "+
+				"with a spend limit of %v", test.name, purchases,
+				test.wantTickets, test.wantLimit)
+		}
+
+		// The tickets were bought at the next stake difficulty of 10
+		// coins.
+		if got := b.purchaser.purchasedDiffPeriod; got != test.wantTickets {
+			t.Errorf("%s: got %v tickets purchased in the window, "+
+				"want %v", test.name, got, test.wantTickets)
+		}
+		spent := int64(dcrutil.Amount(test.wantTickets) * 10e8)
+		if b.purchaser.dca.Remaining != 100e8-spent ||
+			b.purchaser.dca.WindowSpent != spent {
+			t.Errorf("%s: got budget state %+v, want %v spent", test.name,
+				b.purchaser.dca, dcrutil.Amount(spent))
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/decred/dcrwallet/snacl"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// dcrdPassEnv and dcrwPassEnv are the environment variables the RPC
+	// passwords may be read from.
+	dcrdPassEnv = "DCRTICKETBUYER_DCRDPASS"
+	dcrwPassEnv = "DCRTICKETBUYER_DCRWPASS"
+)
+
+// stdinReader reads passphrases from standard input when it is not a
+// terminal.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// secrets are the credentials stored in an encrypted secrets file.
+type secrets struct {
+	DcrdPass   string `json:"dcrdpass"`
+	DcrwPass   string `json:"dcrwpass"`
+	WalletPass string `json:"walletpass,omitempty"`
+}
+
+// secretsFile is the format of an encrypted secrets file. Key holds the
+// parameters of the key derived from the passphrase, and Secrets the
+// encrypted JSON encoding of the secrets.
+type secretsFile struct {
+	Key     []byte `json:"key"`
+	Secrets []byte `json:"secrets"`
+}
+
+// checkSecretFilePerms returns an error if a file containing secrets can
+// be read by all users. Permissions are not checked on Windows.
+func checkSecretFilePerms(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0004 != 0 {
+		return fmt.Errorf("%s contains secrets but is readable by all "+
+			"users, restrict its permissions with chmod o-r", path)
+	}
+	return nil
+}
+
+// readPassFile reads a password from the first line of a file that is not
+// readable by all users.
+func readPassFile(path string) (string, error) {
+	if err := checkSecretFilePerms(path); err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(strings.SplitN(string(b), "\n", 2)[0], "\r"),
+		nil
+}
+
+// promptPassphrase prompts for a passphrase, reading it from the terminal
+// without echoing it, or from a line of standard input if it is not a
+// terminal.
+func promptPassphrase(prompt string) ([]byte, error) {
+	fmt.Print(prompt)
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		pass, err := terminal.ReadPassword(fd)
+		fmt.Println()
+		return pass, err
+	}
+	line, err := stdinReader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// decryptSecrets reads and decrypts a secrets file using passphrase.
+func decryptSecrets(path string, passphrase []byte) (*secrets, error) {
+	if err := checkSecretFilePerms(path); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file secretsFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %v", path, err)
+	}
+
+	var key snacl.SecretKey
+	if err := key.Unmarshal(file.Key); err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %v", path, err)
+	}
+	if err := key.DeriveKey(&passphrase); err != nil {
+		if err == snacl.ErrInvalidPassword {
+			return nil, fmt.Errorf("incorrect passphrase for the "+
+				"secrets file %s", path)
+		}
+		return nil, err
+	}
+	defer key.Zero()
+	plaintext, err := key.Decrypt(file.Secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	var s secrets
+	if err := json.Unmarshal(plaintext, &s); err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %v", path, err)
+	}
+	return &s, nil
+}
+
+// encryptSecrets encrypts the secrets with a key derived from passphrase
+// and writes them to a new file that only the user can read.
+func encryptSecrets(path string, s *secrets, passphrase []byte) error {
+	key, err := snacl.NewSecretKey(&passphrase, snacl.DefaultN,
+		snacl.DefaultR, snacl.DefaultP)
+	if err != nil {
+		return err
+	}
+	defer key.Zero()
+	plaintext, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := key.Encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(&secretsFile{
+		Key:     key.Marshal(),
+		Secrets: ciphertext,
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadCredentials fills in the RPC passwords that are not set in the
+// config. Each password is read from its password file, then from its
+// environment variable and finally from the secrets file, which is only
+// decrypted if it is needed. With autounlock, the wallet passphrase is
+// returned, read from the secrets file or otherwise prompted for.
+func loadCredentials(cfg *config) ([]byte, error) {
+	var s *secrets
+	fromSecrets := func(pass func(*secrets) string) (string, error) {
+		if cfg.SecretsFile == "" {
+			return "", nil
+		}
+		if s == nil {
+			passphrase, err := promptPassphrase("Secrets file passphrase: ")
+			if err != nil {
+				return "", err
+			}
+			s, err = decryptSecrets(cfg.SecretsFile, passphrase)
+			if err != nil {
+				return "", err
+			}
+		}
+		return pass(s), nil
+	}
+
+	passwords := []struct {
+		pass     *string
+		passFile string
+		env      string
+		secret   func(*secrets) string
+	}{
+		{&cfg.DcrdPass, cfg.DcrdPassFile, dcrdPassEnv,
+			func(s *secrets) string { return s.DcrdPass }},
+		{&cfg.DcrwPass, cfg.DcrwPassFile, dcrwPassEnv,
+			func(s *secrets) string { return s.DcrwPass }},
+	}
+	for _, p := range passwords {
+		if *p.pass != "" {
+			continue
+		}
+		if p.passFile != "" {
+			pass, err := readPassFile(p.passFile)
+			if err != nil {
+				return nil, err
+			}
+			*p.pass = pass
+			continue
+		}
+		if pass := os.Getenv(p.env); pass != "" {
+			*p.pass = pass
+			continue
+		}
+		pass, err := fromSecrets(p.secret)
+		if err != nil {
+			return nil, err
+		}
+		*p.pass = pass
+	}
+
+	if !cfg.AutoUnlock {
+		return nil, nil
+	}
+	walletPass, err := fromSecrets(func(s *secrets) string {
+		return s.WalletPass
+	})
+	if err != nil {
+		return nil, err
+	}
+	if walletPass != "" {
+		return []byte(walletPass), nil
+	}
+	pass, err := promptPassphrase("Wallet passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, fmt.Errorf("autounlock is set but no wallet " +
+			"passphrase was given")
+	}
+	return pass, nil
+}
+
+// runCreateSecretsCommand prompts for the RPC passwords and a passphrase
+// and writes them to a new encrypted secrets file, which is the file given
+// as the argument or otherwise the configured secrets file.
+func runCreateSecretsCommand(cfg *config, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: createsecrets [file]")
+	}
+	path := cfg.SecretsFile
+	if len(args) == 1 {
+		path = cleanAndExpandPath(args[0])
+	}
+	if path == "" {
+		return fmt.Errorf("no secrets file given and secretsfile is not " +
+			"set")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("the secrets file %s already exists", path)
+	}
+
+	dcrdPass, err := promptPassphrase("Daemon RPC password: ")
+	if err != nil {
+		return err
+	}
+	dcrwPass, err := promptPassphrase("Wallet RPC password: ")
+	if err != nil {
+		return err
+	}
+	walletPass, err := promptPassphrase("Wallet passphrase for autounlock " +
+		"(empty for none): ")
+	if err != nil {
+		return err
+	}
+	passphrase, err := promptPassphrase("New secrets file passphrase: ")
+	if err != nil {
+		return err
+	}
+	confirm, err := promptPassphrase("Confirm passphrase: ")
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(passphrase, confirm) {
+		return fmt.Errorf("the passphrases do not match")
+	}
+	if len(passphrase) == 0 {
+		return fmt.Errorf("the passphrase must not be empty")
+	}
+
+	err = encryptSecrets(path, &secrets{
+		DcrdPass:   string(dcrdPass),
+		DcrwPass:   string(dcrwPass),
+		WalletPass: string(walletPass),
+	}, passphrase)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote the encrypted secrets file %s\n", path)
+
+	return nil
+}
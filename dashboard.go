@@ -0,0 +1,177 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// dashboardHistory is the number of recent purchases and aborted
+	// rounds shown on the dashboard.
+	dashboardHistory = 8
+
+	// ansiClearScreen moves the cursor to the top left corner of the
+	// terminal and clears the screen.
+	ansiClearScreen = "\x1b[H\x1b[2J"
+
+	// ansiBold and ansiReset start and end bold text.
+	ansiBold  = "\x1b[1m"
+	ansiReset = "\x1b[0m"
+)
+
+// dashboardPurchase is a recently purchased ticket shown on the dashboard.
+// Manually purchased tickets have no height.
+type dashboardPurchase struct {
+	time   time.Time
+	height int32
+	ticket string
+	price  float64
+}
+
+// dashboardAbort is a recent purchase round that was stopped short of its
+// plan by a check, or that failed with an error. Rounds limited by the
+// configured pace of purchases are not aborts.
+type dashboardAbort struct {
+	time   time.Time
+	height int32
+	reason string
+}
+
+// dashboard renders a live view of the ticket buyer to a terminal. It is
+// only used from the purchase manager, so it is not safe for concurrent
+// access.
+type dashboard struct {
+	out           io.Writer
+	winSize       int
+	queueStrategy string
+	height        int32
+	result        *purchaseResult
+	purchases     []dashboardPurchase
+	aborts        []dashboardAbort
+}
+
+// newDashboard creates a new dashboard writing to out.
+func newDashboard(cfg *config, out io.Writer) *dashboard {
+	return &dashboard{
+		out:           out,
+		winSize:       int(activeNet.StakeDiffWindowSize),
+		queueStrategy: cfg.QueueStrategy,
+	}
+}
+
+// roundFinished records the outcome of the purchase round at height. The
+// result is nil if the round failed with err.
+func (d *dashboard) roundFinished(height int32, result *purchaseResult,
+	err error) {
+	d.height = height
+	now := time.Now()
+	if err != nil {
+		d.addAbort(dashboardAbort{now, height, err.Error()})
+		return
+	}
+
+	d.result = result
+	for _, ticket := range result.Tickets {
+		d.addPurchase(dashboardPurchase{now, height, ticket,
+			result.NextStakeDiff})
+	}
+	if result.Reason != reasonNone && !result.Reason.limitsPace() {
+		d.addAbort(dashboardAbort{now, height, result.Reason.String()})
+	}
+}
+
+// manualPurchaseFinished records tickets purchased with the buy command.
+func (d *dashboard) manualPurchaseFinished(tickets []string) {
+	now := time.Now()
+	for _, ticket := range tickets {
+		d.addPurchase(dashboardPurchase{time: now, ticket: ticket})
+	}
+}
+
+// addPurchase adds a purchase, dropping the oldest one if needed.
+func (d *dashboard) addPurchase(p dashboardPurchase) {
+	d.purchases = append(d.purchases, p)
+	if len(d.purchases) > dashboardHistory {
+		d.purchases = d.purchases[len(d.purchases)-dashboardHistory:]
+	}
+}
+
+// addAbort adds an aborted round, dropping the oldest one if needed.
+func (d *dashboard) addAbort(a dashboardAbort) {
+	d.aborts = append(d.aborts, a)
+	if len(d.aborts) > dashboardHistory {
+		d.aborts = d.aborts[len(d.aborts)-dashboardHistory:]
+	}
+}
+
+// render redraws the dashboard.
+func (d *dashboard) render() {
+	var b bytes.Buffer
+	b.WriteString(ansiClearScreen)
+	fmt.Fprintf(&b, "%sdcrticketbuyer%s  %v  %s\n\n", ansiBold, ansiReset,
+		activeNet.Name, time.Now().Format("2006-01-02 15:04:05"))
+
+	r := d.result
+	if r == nil {
+		fmt.Fprintf(&b, "Height            %v\n", d.height)
+		b.WriteString("Waiting for the first purchase round...\n")
+	} else {
+		fmt.Fprintf(&b, "Height            %v (window %v, block %v of %v)\n",
+			r.Height, r.WindowPeriod, r.WindowIdx+1, d.winSize)
+		fmt.Fprintf(&b, "Next stake diff   %.8f\n", r.NextStakeDiff)
+		fmt.Fprintf(&b, "Average price     %.8f\n", r.AvgPrice)
+		switch {
+		case d.queueStrategy != queuePenaltyStr:
+			fmt.Fprintf(&b, "Target price      unused by the %v queue "+
+				"strategy\n", d.queueStrategy)
+		case r.TargetSource == "":
+			b.WriteString("Target price      set when the window queue " +
+				"is filled\n")
+		default:
+			fmt.Fprintf(&b, "Target price      %.8f (from %v)\n",
+				r.TargetPrice, r.TargetSource)
+		}
+		fmt.Fprintf(&b, "Next window est.  %.8f (scaled max %.8f, "+
+			"min %.8f)\n", r.EstimateExpected, r.MaxPriceScaled,
+			r.MinPriceScaled)
+		fmt.Fprintf(&b, "Queue remaining   %v (%v of %v purchased)\n",
+			r.TicketsQueued-r.TicketsPurchased, r.TicketsPurchased,
+			r.TicketsQueued)
+		fmt.Fprintf(&b, "Ticket fee        %.8f/KB\n", r.Fee)
+		fmt.Fprintf(&b, "Balance           %.8f\n", r.Balance)
+		fmt.Fprintf(&b, "Last round        %v\n", r)
+	}
+
+	fmt.Fprintf(&b, "\n%sRecent purchases%s\n", ansiBold, ansiReset)
+	if len(d.purchases) == 0 {
+		b.WriteString("  none\n")
+	}
+	for i := len(d.purchases) - 1; i >= 0; i-- {
+		p := d.purchases[i]
+		if p.height == 0 {
+			fmt.Fprintf(&b, "  %s  manual  %v\n",
+				p.time.Format("15:04:05"), p.ticket)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s  %-6v  %v  %.8f\n",
+			p.time.Format("15:04:05"), p.height, p.ticket, p.price)
+	}
+
+	fmt.Fprintf(&b, "\n%sRecent aborts%s\n", ansiBold, ansiReset)
+	if len(d.aborts) == 0 {
+		b.WriteString("  none\n")
+	}
+	for i := len(d.aborts) - 1; i >= 0; i-- {
+		a := d.aborts[i]
+		fmt.Fprintf(&b, "  %s  %-6v  %v\n", a.time.Format("15:04:05"),
+			a.height, a.reason)
+	}
+
+	d.out.Write(b.Bytes())
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+)
+
+// decisionReason enumerates why a purchase round did not buy any tickets,
+// or why it bought fewer tickets than were requested from the queue.
+type decisionReason int
+
+// These constants define the reasons for a purchase round's decision.
+const (
+	// reasonNone indicates that all requested tickets were purchased.
+	reasonNone decisionReason = iota
+
+	// reasonPurchasingDisabled indicates that purchasing is disabled
+	// because maxperblock is 0.
+	reasonPurchasingDisabled
+
+	// reasonFrequencySkip indicates that this block is skipped because
+	// tickets are only purchased once every abs(maxperblock) blocks.
+	reasonFrequencySkip
+
+	// reasonPriceAboveAbsMax indicates that the next stake difficulty is
+	// above maxpriceabsolute.
+	reasonPriceAboveAbsMax
+
+	// reasonEstimateAboveScaledMax indicates that the next window stake
+	// difficulty estimate is above the scaled maximum price.
+	reasonEstimateAboveScaledMax
+
+	// reasonMempoolWait indicates that more of our own tickets than
+	// maxinmempool are waiting in the mempool.
+	reasonMempoolWait
+
+	// reasonQueueExhausted indicates that all tickets queued for the
+	// window have been purchased.
+	reasonQueueExhausted
+
+	// reasonMaxPerBlock indicates that fewer tickets were purchased
+	// because of the maxperblock limit.
+	reasonMaxPerBlock
+
+	// reasonPacing indicates that fewer tickets were purchased to spread
+	// the queue evenly across the window.
+	reasonPacing
+
+	// reasonBalanceToMaintain indicates that fewer or no tickets were
+	// purchased to keep balancetomaintain in the wallet.
+	reasonBalanceToMaintain
+
+	// reasonWalletShortfall indicates that the wallet purchased fewer
+	// tickets than were requested from it.
+	reasonWalletShortfall
+
+	// reasonMaxOutstanding indicates that fewer or no tickets were
+	// purchased to stay within maxoutstanding live, immature and unmined
+	// tickets.
+	reasonMaxOutstanding
+
+	// reasonMaxLocked indicates that fewer or no tickets were purchased
+	// to stay within maxlocked coins locked in tickets.
+	reasonMaxLocked
+)
+
+// Map of decisionReason values back to their names for pretty printing and
+// machine-readable output.
+var decisionReasonStrings = map[decisionReason]string{
+	reasonNone:                   "None",
+	reasonPurchasingDisabled:     "PurchasingDisabled",
+	reasonFrequencySkip:          "FrequencySkip",
+	reasonPriceAboveAbsMax:       "PriceAboveAbsoluteMax",
+	reasonEstimateAboveScaledMax: "EstimateAboveScaledMax",
+	reasonMempoolWait:            "MempoolWait",
+	reasonQueueExhausted:         "QueueExhausted",
+	reasonMaxPerBlock:            "MaxPerBlock",
+	reasonPacing:                 "Pacing",
+	reasonBalanceToMaintain:      "BalanceToMaintain",
+	reasonWalletShortfall:        "WalletShortfall",
+	reasonMaxOutstanding:         "MaxOutstanding",
+	reasonMaxLocked:              "MaxLocked",
+}
+
+// limitsPace returns whether the reason is one of the limits on how fast
+// the queue is purchased that the configuration asks for, rather than a
+// check that stopped the round short of its plan.
+func (r decisionReason) limitsPace() bool {
+	switch r {
+	case reasonPurchasingDisabled, reasonFrequencySkip, reasonQueueExhausted,
+		reasonMaxPerBlock, reasonPacing:
+		return true
+	}
+	return false
+}
+
+// String returns the decisionReason as a human-readable name.
+func (r decisionReason) String() string {
+	if s := decisionReasonStrings[r]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown decisionReason (%d)", int(r))
+}
+
+// MarshalText satisfies the encoding.TextMarshaler interface so that the
+// reason is encoded by name.
+func (r decisionReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText satisfies the encoding.TextUnmarshaler interface so that
+// stored decisions are decoded from the reason name.
+func (r *decisionReason) UnmarshalText(text []byte) error {
+	for reason, s := range decisionReasonStrings {
+		if s == string(text) {
+			*r = reason
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown decision reason %q", text)
+}
+
+// purchaseResult is the decision made in a purchase round. It is returned
+// for every round that does not fail with an error, so that status
+// surfaces, metrics and tests can inspect why tickets were or were not
+// purchased. Prices and fees are in coins, and fields for values that
+// were not reached before the round ended are left zero.
+type purchaseResult struct {
+	Height           int32          `json:"height"`
+	WindowPeriod     int            `json:"windowperiod"`
+	WindowIdx        int            `json:"windowidx"`
+	NextStakeDiff    float64        `json:"nextstakediff"`
+	AvgPrice         float64        `json:"avgprice"`
+	EstimateExpected float64        `json:"estimateexpected"`
+	MaxPriceScaled   float64        `json:"maxpricescaled"`
+	MinPriceScaled   float64        `json:"minpricescaled"`
+	TargetPrice      float64        `json:"targetprice"`
+	TargetSource     string         `json:"targetsource"`
+	Fee              float64        `json:"fee"`
+	TicketsQueued    int            `json:"ticketsqueued"`
+	TicketsPurchased int            `json:"ticketspurchased"`
+	Requested        int            `json:"requested"`
+	TicketsBought    int            `json:"ticketsbought"`
+	Tickets          []string       `json:"tickets,omitempty"`
+	Balance          float64        `json:"balance"`
+	Reason           decisionReason `json:"reason"`
+	Explain          []string       `json:"explain,omitempty"`
+}
+
+// String returns a short summary of the decision.
+func (r *purchaseResult) String() string {
+	return fmt.Sprintf("bought %v of %v requested %s (reason: %v, "+
+		"window queue %v/%v)", r.TicketsBought, r.Requested,
+		pickNoun(r.Requested, "ticket", "tickets"), r.Reason,
+		r.TicketsPurchased, r.TicketsQueued)
+}
+
+// explainf adds a step to the reasoning behind the decision.
+func (r *purchaseResult) explainf(format string, a ...interface{}) {
+	r.Explain = append(r.Explain, fmt.Sprintf(format, a...))
+}
+
+// finishRound completes the result of a purchase round with the state of
+// the window queue and the reason for the decision.
+func (t *ticketPurchaser) finishRound(result *purchaseResult,
+	reason decisionReason) *purchaseResult {
+	result.TicketsQueued = t.toBuyDiffPeriod
+	result.TicketsPurchased = t.purchasedDiffPeriod
+	result.TargetPrice = t.targetPrice
+	result.TargetSource = t.targetSource
+	result.Reason = reason
+	result.explainf("Final count: bought %v of %v requested %s, %v of %v "+
+		"queued tickets purchased this window (reason: %v)",
+		result.TicketsBought, result.Requested, pickNoun(result.Requested,
+			"ticket", "tickets"), result.TicketsPurchased,
+		result.TicketsQueued, reason)
+	t.lastResult = result
+	return result
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/decred/dcrd/dcrjson"
+	"github.com/decred/dcrrpcclient"
+)
+
+// ErrorCode identifies a kind of error so that it can be handled and
+// reported in a machine-readable way.
+type ErrorCode int
+
+// These constants are used to identify a specific purchaseError.
+const (
+	// ErrUnknown indicates an error that has not been classified.
+	ErrUnknown ErrorCode = iota
+
+	// ErrRPCServer indicates that an RPC server returned an error for a
+	// request.
+	ErrRPCServer
+
+	// ErrRPCConnection indicates that an RPC client is not connected to
+	// its server or was shut down.
+	ErrRPCConnection
+
+	// ErrWalletNotConnected indicates that the wallet is not connected to
+	// its daemon.
+	ErrWalletNotConnected
+
+	// ErrWalletLocked indicates that the wallet is locked and can not
+	// purchase tickets.
+	ErrWalletLocked
+
+	// ErrInvalidAmount indicates that a coin amount from the chain or the
+	// configuration could not be converted.
+	ErrInvalidAmount
+)
+
+// Map of ErrorCode values back to their constant names for pretty printing.
+var errorCodeStrings = map[ErrorCode]string{
+	ErrUnknown:            "ErrUnknown",
+	ErrRPCServer:          "ErrRPCServer",
+	ErrRPCConnection:      "ErrRPCConnection",
+	ErrWalletNotConnected: "ErrWalletNotConnected",
+	ErrWalletLocked:       "ErrWalletLocked",
+	ErrInvalidAmount:      "ErrInvalidAmount",
+}
+
+// String returns the ErrorCode as a human-readable name.
+func (e ErrorCode) String() string {
+	if s := errorCodeStrings[e]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown ErrorCode (%d)", int(e))
+}
+
+// purchaseError identifies an error encountered while purchasing tickets.
+// The caller can ascertain the specific reason for the error by type
+// asserting it and checking the error code.
+type purchaseError struct {
+	ErrorCode   ErrorCode // Describes the kind of error
+	Description string    // Human readable description of the issue
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e purchaseError) Error() string {
+	return e.Description
+}
+
+// purchaseErrorf creates a purchaseError given a set of arguments.
+func purchaseErrorf(c ErrorCode, format string, a ...interface{}) purchaseError {
+	return purchaseError{ErrorCode: c, Description: fmt.Sprintf(format, a...)}
+}
+
+// errorCode returns the ErrorCode of an error, classifying errors returned
+// by the RPC clients.
+func errorCode(err error) ErrorCode {
+	switch e := err.(type) {
+	case purchaseError:
+		return e.ErrorCode
+	case *dcrjson.RPCError:
+		return ErrRPCServer
+	}
+
+	switch err {
+	case dcrrpcclient.ErrClientNotConnected,
+		dcrrpcclient.ErrClientDisconnect,
+		dcrrpcclient.ErrClientShutdown:
+		return ErrRPCConnection
+	}
+
+	return ErrUnknown
+}
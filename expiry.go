@@ -0,0 +1,125 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+)
+
+var (
+	// expiryFixedStr is the string indicating that tickets expire
+	// expirydelta blocks after they are purchased.
+	expiryFixedStr = "fixed"
+
+	// expiryWindowStr is the string indicating that tickets expire
+	// expirydelta blocks after they are purchased, but never after the
+	// end of the stake difficulty window they were priced for.
+	expiryWindowStr = "window"
+
+	// expiryDynamicStr is the string indicating that the expiry of tickets
+	// is extended by the blocks needed to mine the tickets already in the
+	// mempool, but never past the end of the stake difficulty window they
+	// were priced for.
+	expiryDynamicStr = "dynamic"
+)
+
+// windowEndExpiry returns the expiry height that lets a ticket purchased
+// at height be mined up to the last block of the stake difficulty window
+// it is priced for, which is the window of the next block.
+func windowEndExpiry(height int32) int32 {
+	winSize := int32(activeNet.StakeDiffWindowSize)
+	return ((height+1)/winSize + 1) * winSize
+}
+
+// ticketExpiry returns the expiry height of a ticket purchased at height
+// according to the expiry mode.
+func (t *ticketPurchaser) ticketExpiry(height int32) (int32, error) {
+	expiry := height + int32(t.cfg.ExpiryDelta)
+	if t.cfg.ExpiryMode == expiryFixedStr {
+		return expiry, nil
+	}
+
+	if t.cfg.ExpiryMode == expiryDynamicStr {
+		stakeInfo, err := t.dcrwChainSvr.GetStakeInfo()
+		if err != nil {
+			return 0, err
+		}
+		blocks := int32(math.Ceil(float64(stakeInfo.AllMempoolTix) /
+			float64(activeNet.MaxFreshStakePerBlock)))
+		log.Tracef("Extending the ticket expiry by %v blocks for the %v "+
+			"tickets in the mempool", blocks, stakeInfo.AllMempoolTix)
+		expiry += blocks
+	}
+
+	if end := windowEndExpiry(height); expiry > end {
+		log.Tracef("Limiting the ticket expiry from %v to the end of the "+
+			"window at %v", expiry, end)
+		expiry = end
+	}
+	return expiry, nil
+}
+
+// trackExpiry remembers the expiry of purchased tickets until they are
+// mined or expire.
+func (t *ticketPurchaser) trackExpiry(tickets []*chainhash.Hash,
+	expiry int32) {
+	for _, hash := range tickets {
+		t.pendingExpiry[*hash] = expiry
+	}
+}
+
+// ticketMined returns whether the daemon has the ticket in a block, which
+// is the case while its stake submission output is unspent and confirmed.
+func (t *ticketPurchaser) ticketMined(hash chainhash.Hash) (bool, error) {
+	txOut, err := t.dcrdChainSvr.GetTxOut(&hash, 0, false)
+	if err != nil {
+		return false, err
+	}
+	return txOut != nil && txOut.Confirmations > 0, nil
+}
+
+// checkExpired forgets the purchased tickets that were mined in the block
+// at height and logs those that expired without being mined. Since blocks
+// may be missed while the ticket buyer is disconnected, a ticket that
+// reaches its expiry is only reported as expired once the daemon confirms
+// that it was not mined.
+func (t *ticketPurchaser) checkExpired(height int32) error {
+	if len(t.pendingExpiry) == 0 {
+		return nil
+	}
+
+	hash, err := t.dcrdChainSvr.GetBlockHash(int64(height))
+	if err != nil {
+		return err
+	}
+	block, err := t.dcrdChainSvr.GetBlock(hash)
+	if err != nil {
+		return err
+	}
+	for _, tx := range block.MsgBlock().STransactions {
+		delete(t.pendingExpiry, tx.TxSha())
+	}
+
+	for hash, expiry := range t.pendingExpiry {
+		if height < expiry {
+			continue
+		}
+		mined, err := t.ticketMined(hash)
+		if err != nil {
+			return err
+		}
+		if mined {
+			log.Debugf("Ticket %v was mined before its expiry at height %v",
+				hash, expiry)
+		} else {
+			log.Warnf("Ticket %v expired at height %v without being mined",
+				hash, expiry)
+		}
+		delete(t.pendingExpiry, hash)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+)
+
+// TestWindowEndExpiry ensures tickets expire at the end of the window of
+// the block after the one they were purchased in, which is the next window
+// for tickets purchased at the last block of a window.
+func TestWindowEndExpiry(t *testing.T) {
+	winSize := int32(activeNet.StakeDiffWindowSize)
+	tests := []struct {
+		height int32
+		want   int32
+	}{
+		{0, winSize},
+		{winSize - 2, winSize},
+		{winSize - 1, 2 * winSize},
+		{winSize, 2 * winSize},
+		{2*winSize - 2, 2 * winSize},
+		{2*winSize - 1, 3 * winSize},
+	}
+
+	for _, test := range tests {
+		got := windowEndExpiry(test.height)
+		if got != test.want {
+			t.Errorf("height %v: got expiry %v, want %v", test.height, got,
+				test.want)
+		}
+	}
+}
+
+// TestCheckExpired ensures purchased tickets are tracked until their
+// expiry, and that the daemon is asked at the expiry whether each ticket
+// was mined or expired. Tickets are purchased at height 301 and expire at
+// height 303.
+func TestCheckExpired(t *testing.T) {
+	tests := []struct {
+		name      string
+		evicted   bool
+		wantMined bool
+	}{
+		{"mined", false, true},
+		{"evicted from the mempool", true, false},
+	}
+
+	for _, test := range tests {
+		h := newTestHarness(t, 300)
+		cfg := newTestConfig(h)
+		cfg.ExpiryMode = expiryFixedStr
+		cfg.ExpiryDelta = 2
+		cfg.MaxOutstanding = defaultMaxPerBlock
+		b := startTestBuyer(t, h, cfg, nil, false)
+
+		b.connectBlock()
+		if test.evicted {
+			h.Dcrd.DropMempool()
+		}
+		b.connectBlock()
+
+		pending := len(b.purchaser.pendingExpiry)
+		if pending != defaultMaxPerBlock {
+			t.Errorf("%s: got %v tickets pending before the expiry, want %v",
+				test.name, pending, defaultMaxPerBlock)
+		}
+		var tickets []string
+		for _, p := range h.Wallet.Purchases() {
+			tickets = append(tickets, p.Tickets...)
+		}
+		for _, ticket := range tickets {
+			hash, err := chainhash.NewHashFromStr(ticket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mined, err := b.purchaser.ticketMined(*hash)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+				continue
+			}
+			if mined != test.wantMined {
+				t.Errorf("%s: ticket %v: got mined %v, want %v", test.name,
+					ticket, mined, test.wantMined)
+			}
+		}
+
+		b.connectBlock()
+		if pending := len(b.purchaser.pendingExpiry); pending != 0 {
+			t.Errorf("%s: got %v tickets pending after the expiry, want 0",
+				test.name, pending)
+		}
+		b.stop()
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// runExplainCommand prints the reasoning behind the decision of the latest
+// purchase round stored in the history, or of the round at the given
+// height.
+func runExplainCommand(cfg *config, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: explain [height]")
+	}
+	height := int64(-1)
+	if len(args) == 1 {
+		var err error
+		height, err = strconv.ParseInt(args[0], 10, 32)
+		if err != nil || height < 0 {
+			return fmt.Errorf("invalid height %v", args[0])
+		}
+	}
+	if cfg.NoHistory {
+		return fmt.Errorf("the history database is disabled")
+	}
+
+	var result *purchaseResult
+	err := readHistory(cfg, func(h historyReader) error {
+		var err error
+		result, err = h.round(int32(height))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		if height < 0 {
+			return fmt.Errorf("no purchase rounds are stored in the history")
+		}
+		return fmt.Errorf("no purchase round is stored for height %v",
+			height)
+	}
+
+	fmt.Printf("Purchase round at height %v (window %v, block %v of %v): "+
+		"%v\n", result.Height, result.WindowPeriod, result.WindowIdx+1,
+		activeNet.StakeDiffWindowSize, result)
+	if len(result.Explain) == 0 {
+		fmt.Printf("No reasoning was stored for this round\n")
+		return nil
+	}
+	for i, step := range result.Explain {
+		fmt.Printf("%3d. %s\n", i+1, step)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/decred/dcrutil"
+)
+
+// fundingAvailable returns the amount that may be transferred from the
+// funding account for the window about to open, which is its spendable
+// balance limited to fundingmaxtransfer.
+func (t *ticketPurchaser) fundingAvailable() (dcrutil.Amount, error) {
+	if t.cfg.FundingAccount == "" {
+		return 0, nil
+	}
+	bal, err := t.dcrwChainSvr.GetBalanceMinConfType(t.cfg.FundingAccount,
+		1, "spendable")
+	if err != nil {
+		return 0, err
+	}
+	if t.cfg.FundingMaxTransfer > 0.0 {
+		maxTransfer, err := dcrutil.NewAmount(t.cfg.FundingMaxTransfer)
+		if err != nil {
+			return 0, err
+		}
+		if bal > maxTransfer {
+			bal = maxTransfer
+		}
+	}
+	return bal, nil
+}
+
+// fundingTransferFee estimates the fee at txfee of a transfer from the
+// funding account that spends every one of its spendable outputs and
+// returns change, which is the most a transfer of up to its whole balance
+// may pay.
+func (t *ticketPurchaser) fundingTransferFee() (dcrutil.Amount, error) {
+	feePerKB, err := dcrutil.NewAmount(t.cfg.TxFee)
+	if err != nil {
+		return 0, err
+	}
+	unspent, err := t.dcrwChainSvr.ListUnspentMin(1)
+	if err != nil {
+		return 0, err
+	}
+	numInputs := 0
+	for _, u := range unspent {
+		if u.Account == t.cfg.FundingAccount && u.Spendable {
+			numInputs++
+		}
+	}
+	if numInputs == 0 {
+		numInputs = 1
+	}
+	return consolidationFee(numInputs, 2, feePerKB), nil
+}
+
+// fundWindow tops up the account from the funding account with what the
+// tickets queued for the window opening at height need at price, plus
+// their fees and the balance to maintain, less the spendable balance
+// already in the account. No more than available less the estimated fee
+// of the transfer is transferred, so that the funding account can pay
+// the fee. The amount transferred is returned.
+func (t *ticketPurchaser) fundWindow(height int32, toBuy int,
+	price, balSpendable, available dcrutil.Amount) (dcrutil.Amount, error) {
+	if t.cfg.FundingAccount == "" || toBuy <= 0 || available <= 0 {
+		return 0, nil
+	}
+	fee, err := t.estimatedTicketFee()
+	if err != nil {
+		return 0, err
+	}
+	balanceToMaintain, err := dcrutil.NewAmount(t.cfg.BalanceToMaintain)
+	if err != nil {
+		return 0, err
+	}
+	need := dcrutil.Amount(toBuy)*(price+fee) + balanceToMaintain -
+		balSpendable
+	if need <= 0 {
+		log.Debugf("Not funding the window at height %v: the spendable "+
+			"balance %v covers the %v queued tickets", height, balSpendable,
+			toBuy)
+		return 0, nil
+	}
+	transferFee, err := t.fundingTransferFee()
+	if err != nil {
+		return 0, err
+	}
+	if available <= transferFee {
+		log.Debugf("Not funding the window at height %v: the %v available "+
+			"in account '%s' does not cover the transfer fee of %v", height,
+			available, t.cfg.FundingAccount, transferFee)
+		return 0, nil
+	}
+	if need > available-transferFee {
+		log.Debugf("Limiting the transfer from account '%s' from %v to %v, "+
+			"reserving %v for the transfer fee", t.cfg.FundingAccount, need,
+			available-transferFee, transferFee)
+		need = available - transferFee
+	}
+
+	addr, err := t.dcrwChainSvr.GetNewAddress(t.cfg.AccountName)
+	if err != nil {
+		return 0, err
+	}
+	amounts := map[dcrutil.Address]dcrutil.Amount{addr: need}
+	var txHash string
+	err = t.withUnlockedWallet(func() error {
+		hash, err := t.dcrwChainSvr.SendMany(t.cfg.FundingAccount, amounts)
+		if err != nil {
+			return err
+		}
+		txHash = hash.String()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	log.Infof("Transferred %v from account '%s' to account '%s' for %v "+
+		"queued %s at height %v in transaction %v", need,
+		t.cfg.FundingAccount, t.cfg.AccountName, toBuy,
+		pickNoun(toBuy, "ticket", "tickets"), height, txHash)
+
+	return need, nil
+}
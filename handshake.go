@@ -0,0 +1,235 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/decred/dcrrpcclient"
+)
+
+// Minimum major versions of the JSON-RPC APIs of dcrd and dcrwallet that
+// the ticket buyer works with, keyed by the names the servers report them
+// under in the version RPC.
+var requiredAPIVersions = map[string]uint32{
+	"dcrdjsonrpcapi":      1,
+	"dcrwalletjsonrpcapi": 1,
+}
+
+// requiredDcrdMethods are the dcrd RPC methods the ticket buyer calls.
+var requiredDcrdMethods = []string{
+	"estimatestakediff",
+	"getbestblockhash",
+	"getblock",
+	"getblockcount",
+	"getblockhash",
+	"getrawmempool",
+	"getrawtransaction",
+	"getstakedifficulty",
+	"getticketpoolvalue",
+	"gettxout",
+	"ticketfeeinfo",
+	"ticketvwap",
+}
+
+// requiredWalletMethods are the dcrwallet RPC methods the ticket buyer
+// calls.
+var requiredWalletMethods = []string{
+	"getbalance",
+	"getrawchangeaddress",
+	"getstakedifficulty",
+	"getstakeinfo",
+	"listaccounts",
+	"purchaseticket",
+	"setticketfee",
+	"settxfee",
+	"walletinfo",
+}
+
+// splitCoordinatorDcrdMethods are the additional dcrd RPC methods a split
+// ticket coordinator calls.
+var splitCoordinatorDcrdMethods = []string{
+	"sendrawtransaction",
+}
+
+// splitParticipantWalletMethods are the additional dcrwallet RPC methods a
+// split ticket participant calls.
+var splitParticipantWalletMethods = []string{
+	"getnewaddress",
+	"listunspent",
+	"lockunspent",
+	"signrawtransaction",
+}
+
+// autoUnlockWalletMethods are the additional dcrwallet RPC methods used to
+// unlock and relock the wallet with autounlock.
+var autoUnlockWalletMethods = []string{
+	"walletlock",
+	"walletpassphrase",
+}
+
+// consolidateDcrdMethods and consolidateWalletMethods are the additional
+// dcrd and dcrwallet RPC methods used to consolidate outputs.
+var (
+	consolidateDcrdMethods = []string{
+		"sendrawtransaction",
+	}
+	consolidateWalletMethods = []string{
+		"listunspent",
+		"signrawtransaction",
+	}
+)
+
+// preSplitWalletMethods are the additional dcrwallet RPC methods used to
+// pre-split outputs.
+var preSplitWalletMethods = []string{
+	"getnewaddress",
+	"sendmany",
+}
+
+// fundingWalletMethods are the additional dcrwallet RPC methods used to
+// transfer funds from the funding account.
+var fundingWalletMethods = []string{
+	"getnewaddress",
+	"listunspent",
+	"sendmany",
+}
+
+// versionResult is a single entry of the result of the version RPC.
+type versionResult struct {
+	VersionString string `json:"versionstring"`
+	Major         uint32 `json:"major"`
+	Minor         uint32 `json:"minor"`
+	Patch         uint32 `json:"patch"`
+}
+
+// checkAPIVersion queries the version of an RPC server, logs it and
+// checks that the JSON-RPC API named api is recent enough.
+func checkAPIVersion(client *dcrrpcclient.Client, server, api string) error {
+	raw, err := client.RawRequest("version", nil)
+	if err != nil {
+		return fmt.Errorf("%s does not report its version, it is likely "+
+			"too old: %v", server, err)
+	}
+	var versions map[string]versionResult
+	if err := json.Unmarshal(raw, &versions); err != nil {
+		return fmt.Errorf("failed to decode the %s version: %v", server, err)
+	}
+	log.Infof("Connected to %s version %s (JSON-RPC API %s)", server,
+		versions[server].VersionString, versions[api].VersionString)
+
+	apiVersion, ok := versions[api]
+	if !ok {
+		return fmt.Errorf("%s does not report a JSON-RPC API version", server)
+	}
+	if apiVersion.Major < requiredAPIVersions[api] {
+		return fmt.Errorf("%s JSON-RPC API version %s is too old, at least "+
+			"%d.0.0 is required", server, apiVersion.VersionString,
+			requiredAPIVersions[api])
+	}
+	return nil
+}
+
+// checkNetwork checks that an RPC server is running on the active network.
+func checkNetwork(client *dcrrpcclient.Client, server string) error {
+	net, err := client.GetCurrentNet()
+	if err != nil {
+		return fmt.Errorf("failed to query the %s network: %v", server, err)
+	}
+	if net != activeNet.Net {
+		return fmt.Errorf("%s is running on %v, but the ticket buyer is "+
+			"configured for %v", server, net, activeNet.Net)
+	}
+	return nil
+}
+
+// checkMethods checks that an RPC server provides each method, returning
+// an error for each one that it does not.
+func checkMethods(client *dcrrpcclient.Client, server string,
+	methods []string) []error {
+	var errs []error
+	for _, method := range methods {
+		param, err := json.Marshal(method)
+		if err != nil {
+			return append(errs, err)
+		}
+		_, err = client.RawRequest("help", []json.RawMessage{param})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s does not support the %s "+
+				"method: %v", server, method, err))
+		}
+	}
+	return errs
+}
+
+// checkCompatibility queries the versions and networks of dcrd and
+// dcrwallet and checks that they provide every RPC method the ticket buyer
+// uses and that the configured account exists, so that incompatibilities
+// are found at startup rather than in the middle of a purchase. An error
+// is returned for each problem found.
+func checkCompatibility(cfg *config, dcrdClient,
+	dcrwClient *dcrrpcclient.Client) []error {
+	var errs []error
+
+	if err := checkAPIVersion(dcrdClient, "dcrd", "dcrdjsonrpcapi"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkAPIVersion(dcrwClient, "dcrwallet",
+		"dcrwalletjsonrpcapi"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := checkNetwork(dcrdClient, "dcrd"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkNetwork(dcrwClient, "dcrwallet"); err != nil {
+		errs = append(errs, err)
+	}
+
+	dcrdMethods := requiredDcrdMethods
+	walletMethods := requiredWalletMethods
+	switch cfg.SplitMode {
+	case splitCoordinatorStr:
+		dcrdMethods = append(dcrdMethods, splitCoordinatorDcrdMethods...)
+	case splitParticipantStr:
+		walletMethods = append(walletMethods,
+			splitParticipantWalletMethods...)
+	}
+	if cfg.AutoUnlock {
+		walletMethods = append(walletMethods, autoUnlockWalletMethods...)
+	}
+	if cfg.PreSplitOutputs > 0 {
+		walletMethods = append(walletMethods, preSplitWalletMethods...)
+	}
+	if cfg.FundingAccount != "" {
+		walletMethods = append(walletMethods, fundingWalletMethods...)
+	}
+	if cfg.Consolidate {
+		dcrdMethods = append(dcrdMethods, consolidateDcrdMethods...)
+		walletMethods = append(walletMethods, consolidateWalletMethods...)
+	}
+	errs = append(errs, checkMethods(dcrdClient, "dcrd", dcrdMethods)...)
+	errs = append(errs, checkMethods(dcrwClient, "dcrwallet",
+		walletMethods)...)
+
+	accounts, err := dcrwClient.ListAccounts()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to list the wallet "+
+			"accounts: %v", err))
+	} else {
+		if _, ok := accounts[cfg.AccountName]; !ok {
+			errs = append(errs, fmt.Errorf("account '%s' does not exist "+
+				"in the wallet", cfg.AccountName))
+		}
+		_, ok := accounts[cfg.FundingAccount]
+		if cfg.FundingAccount != "" && !ok {
+			errs = append(errs, fmt.Errorf("funding account '%s' does "+
+				"not exist in the wallet", cfg.FundingAccount))
+		}
+	}
+
+	return errs
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"bytes"
+	"math"
 	"time"
 
 	"github.com/decred/dcrd/dcrjson"
@@ -70,3 +71,50 @@ func (t *ticketPurchaser) ownTicketsInMempool() (int, error) {
 
 	return int(curStakeInfo.OwnMempoolTix), nil
 }
+
+// outstandingRoom returns how many more tickets may be purchased at price
+// without owning more than maxoutstanding live, immature and unmined
+// tickets or locking more than maxlocked coins in tickets, along with the
+// reason for the tighter limit. A negative number of tickets means that
+// neither cap is enabled.
+func (t *ticketPurchaser) outstandingRoom(price dcrutil.Amount) (int,
+	decisionReason, error) {
+	room := -1
+	reason := reasonNone
+
+	if t.cfg.MaxOutstanding > 0 {
+		stakeInfo, err := t.dcrwChainSvr.GetStakeInfo()
+		if err != nil {
+			return 0, reasonNone, err
+		}
+		outstanding := int(stakeInfo.Live + stakeInfo.Immature +
+			stakeInfo.OwnMempoolTix)
+		room = t.cfg.MaxOutstanding - outstanding
+		reason = reasonMaxOutstanding
+		log.Tracef("Currently %v tickets outstanding (%v live, %v immature,"+
+			" %v in mempool) of %v allowed", outstanding, stakeInfo.Live,
+			stakeInfo.Immature, stakeInfo.OwnMempoolTix,
+			t.cfg.MaxOutstanding)
+	}
+
+	if t.cfg.MaxLocked > 0.0 && price > 0 {
+		locked, err := t.dcrwChainSvr.GetBalanceMinConfType(t.cfg.AccountName,
+			0, "locked")
+		if err != nil {
+			return 0, reasonNone, err
+		}
+		lockedRoom := int(math.Floor((t.cfg.MaxLocked - locked.ToCoin()) /
+			price.ToCoin()))
+		if room < 0 || lockedRoom < room {
+			room = lockedRoom
+			reason = reasonMaxLocked
+		}
+		log.Tracef("Currently %v locked in tickets of %v allowed", locked,
+			t.cfg.MaxLocked)
+	}
+
+	if room < 0 && reason != reasonNone {
+		room = 0
+	}
+	return room, reason, nil
+}
@@ -0,0 +1,426 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// defaultHistoryFilename is the name of the history database written
+	// to the data directory.
+	defaultHistoryFilename = "history.db"
+
+	// historyOpenTimeout is how long to wait for the history database to
+	// be released by another process before giving up.
+	historyOpenTimeout = 5 * time.Second
+)
+
+var (
+	// blocksBucketName is the bucket of per-block observations.
+	blocksBucketName = []byte("blocks")
+
+	// roundsBucketName is the bucket of per-round purchase decisions.
+	roundsBucketName = []byte("rounds")
+
+	// stateBucketName is the bucket of the state the ticket buyer resumes
+	// after a restart.
+	stateBucketName = []byte("state")
+
+	// dcaStateKey is the key of the dollar-cost averaging budget in the
+	// state bucket.
+	dcaStateKey = []byte("dca")
+)
+
+// blockObservation is the chain state observed when a block is connected.
+// Prices and fees are in coins.
+type blockObservation struct {
+	Height        int32   `json:"height"`
+	Time          int64   `json:"time"`
+	StakeDiff     float64 `json:"stakediff"`
+	NextStakeDiff float64 `json:"nextstakediff"`
+	EstimateMin   float64 `json:"estimatemin"`
+	EstimateExp   float64 `json:"estimateexpected"`
+	EstimateMax   float64 `json:"estimatemax"`
+	PoolSize      uint32  `json:"poolsize"`
+	PoolValue     float64 `json:"poolvalue"`
+	VWAP          float64 `json:"vwap"`
+	FeeMean       float64 `json:"feemean"`
+	FeeMedian     float64 `json:"feemedian"`
+}
+
+// historyReader reads the stored block observations and purchase
+// decisions, either from the history database or through the control
+// server of the running ticket buyer.
+type historyReader interface {
+	// forEach calls f with the JSON encoded value of every height from
+	// from to to, inclusive, stored in a bucket, in order of height.
+	forEach(bucket []byte, from, to int32, f func(v []byte) error) error
+
+	// round returns the stored decision of the purchase round at height,
+	// or of the latest round if height is negative, or nil if there is
+	// none.
+	round(height int32) (*purchaseResult, error)
+}
+
+// history stores block observations and purchase decisions in a bolt
+// database. The ticket buyer keeps the database open for as long as it
+// runs, which locks it against other processes, so the history of a
+// running ticket buyer is read through its control server.
+type history struct {
+	db *bolt.DB
+}
+
+// openHistory opens the history database at path, creating it unless
+// readOnly is set. A database that is locked by a running ticket buyer
+// can not be opened.
+func openHistory(path string, readOnly bool) (*history, error) {
+	if readOnly {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	} else if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout:  historyOpenTimeout,
+		ReadOnly: readOnly,
+	})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("the history database %v is in use, set "+
+			"controllisten to read it through the running ticket buyer",
+			path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &history{db: db}, nil
+}
+
+// close closes the history database.
+func (h *history) close() error {
+	return h.db.Close()
+}
+
+// heightKey returns the database key of a height, which sorts by height.
+func heightKey(height int32) []byte {
+	var k [4]byte
+	binary.BigEndian.PutUint32(k[:], uint32(height))
+	return k[:]
+}
+
+// put stores a JSON encoded value for a height in a bucket.
+func (h *history) put(bucket []byte, height int32, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(heightKey(height), b)
+	})
+}
+
+// putState stores a JSON encoded value for a key in the state bucket.
+func (h *history) putState(key []byte, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(stateBucketName)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(key, b)
+	})
+}
+
+// state decodes the JSON encoded value of a key in the state bucket into
+// v, returning whether it was found.
+func (h *history) state(key []byte, v interface{}) (bool, error) {
+	found := false
+	err := h.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(stateBucketName)
+		if bkt == nil {
+			return nil
+		}
+		b := bkt.Get(key)
+		if b == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(b, v)
+	})
+	return found, err
+}
+
+// putBlock stores the observation of a block.
+func (h *history) putBlock(obs *blockObservation) error {
+	return h.put(blocksBucketName, obs.Height, obs)
+}
+
+// putRound stores the decision of a purchase round.
+func (h *history) putRound(result *purchaseResult) error {
+	return h.put(roundsBucketName, result.Height, result)
+}
+
+// forEach calls f with the JSON encoded value of every height from from
+// to to, inclusive, stored in a bucket, in order of height.
+func (h *history) forEach(bucket []byte, from, to int32,
+	f func(v []byte) error) error {
+	return h.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(bucket)
+		if bkt == nil {
+			return nil
+		}
+		c := bkt.Cursor()
+		for k, v := c.Seek(heightKey(from)); k != nil; k, v = c.Next() {
+			if int32(binary.BigEndian.Uint32(k)) > to {
+				break
+			}
+			if err := f(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// round returns the stored decision of the purchase round at height, or
+// of the latest round if height is negative, or nil if there is none.
+func (h *history) round(height int32) (*purchaseResult, error) {
+	var result *purchaseResult
+	err := h.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(roundsBucketName)
+		if bkt == nil {
+			return nil
+		}
+		var v []byte
+		if height < 0 {
+			_, v = bkt.Cursor().Last()
+		} else {
+			v = bkt.Get(heightKey(height))
+		}
+		if v == nil {
+			return nil
+		}
+		result = new(purchaseResult)
+		return json.Unmarshal(v, result)
+	})
+	return result, err
+}
+
+// recordBlock observes the chain state at a newly connected block and
+// stores it in the history.
+func (t *ticketPurchaser) recordBlock(height int32) error {
+	if t.history == nil {
+		return nil
+	}
+
+	hash, err := t.dcrdChainSvr.GetBlockHash(int64(height))
+	if err != nil {
+		return err
+	}
+	block, err := t.dcrdChainSvr.GetBlock(hash)
+	if err != nil {
+		return err
+	}
+	header := &block.MsgBlock().Header
+	obs := &blockObservation{
+		Height:    height,
+		Time:      header.Timestamp.Unix(),
+		StakeDiff: float64(header.SBits) / 1e8,
+		PoolSize:  header.PoolSize,
+	}
+
+	stakeDiffs, err := t.dcrdChainSvr.GetStakeDifficulty()
+	if err != nil {
+		return err
+	}
+	obs.NextStakeDiff = stakeDiffs.NextStakeDifficulty
+	sDiffEsts, err := t.dcrdChainSvr.EstimateStakeDiff(nil)
+	if err != nil {
+		return err
+	}
+	obs.EstimateMin = sDiffEsts.Min
+	obs.EstimateExp = sDiffEsts.Expected
+	obs.EstimateMax = sDiffEsts.Max
+	poolValue, err := t.dcrdChainSvr.GetTicketPoolValue()
+	if err != nil {
+		return err
+	}
+	obs.PoolValue = poolValue.ToCoin()
+	vwap, err := t.ticketVWAP(height)
+	if err != nil {
+		return err
+	}
+	obs.VWAP = vwap.ToCoin()
+	oneBlock := uint32(1)
+	info, err := t.dcrdChainSvr.TicketFeeInfo(&oneBlock, &zeroUint32)
+	if err != nil {
+		return err
+	}
+	if len(info.FeeInfoBlocks) > 0 {
+		obs.FeeMean = info.FeeInfoBlocks[0].Mean
+		obs.FeeMedian = info.FeeInfoBlocks[0].Median
+	}
+
+	return t.history.putBlock(obs)
+}
+
+// blockColumns and roundColumns are the columns printed by the query
+// command.
+var (
+	blockColumns = []string{"height", "time", "stakediff", "nextstakediff",
+		"estmin", "estexpected", "estmax", "poolsize", "poolvalue", "vwap",
+		"feemean", "feemedian"}
+	roundColumns = []string{"height", "window", "idx", "nextstakediff",
+		"avgprice", "estexpected", "maxscaled", "minscaled", "fee", "queued",
+		"purchased", "requested", "bought", "balance", "reason"}
+)
+
+// formatCoin formats an amount in coins for the query command.
+func formatCoin(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// blockRow returns the columns of a stored block observation.
+func blockRow(v []byte) ([]string, error) {
+	var obs blockObservation
+	if err := json.Unmarshal(v, &obs); err != nil {
+		return nil, err
+	}
+	return []string{
+		strconv.Itoa(int(obs.Height)),
+		time.Unix(obs.Time, 0).UTC().Format(time.RFC3339),
+		formatCoin(obs.StakeDiff),
+		formatCoin(obs.NextStakeDiff),
+		formatCoin(obs.EstimateMin),
+		formatCoin(obs.EstimateExp),
+		formatCoin(obs.EstimateMax),
+		strconv.Itoa(int(obs.PoolSize)),
+		formatCoin(obs.PoolValue),
+		formatCoin(obs.VWAP),
+		formatCoin(obs.FeeMean),
+		formatCoin(obs.FeeMedian),
+	}, nil
+}
+
+// roundRow returns the columns of a stored purchase decision.
+func roundRow(v []byte) ([]string, error) {
+	var r purchaseResult
+	if err := json.Unmarshal(v, &r); err != nil {
+		return nil, err
+	}
+	return []string{
+		strconv.Itoa(int(r.Height)),
+		strconv.Itoa(r.WindowPeriod),
+		strconv.Itoa(r.WindowIdx),
+		formatCoin(r.NextStakeDiff),
+		formatCoin(r.AvgPrice),
+		formatCoin(r.EstimateExpected),
+		formatCoin(r.MaxPriceScaled),
+		formatCoin(r.MinPriceScaled),
+		formatCoin(r.Fee),
+		strconv.Itoa(r.TicketsQueued),
+		strconv.Itoa(r.TicketsPurchased),
+		strconv.Itoa(r.Requested),
+		strconv.Itoa(r.TicketsBought),
+		formatCoin(r.Balance),
+		r.Reason.String(),
+	}, nil
+}
+
+// runQueryCommand prints the stored block observations or purchase
+// decisions in a range of heights as a table, or as CSV if the last
+// argument is csv.
+func runQueryCommand(cfg *config, args []string) error {
+	asCSV := false
+	if len(args) > 0 && args[len(args)-1] == "csv" {
+		args = args[:len(args)-1]
+		asCSV = true
+	}
+	usage := fmt.Errorf("usage: query <blocks|rounds> [fromheight " +
+		"[toheight]] [csv]")
+	if len(args) < 1 || len(args) > 3 {
+		return usage
+	}
+	var bucket []byte
+	var columns []string
+	var row func([]byte) ([]string, error)
+	switch args[0] {
+	case "blocks":
+		bucket, columns, row = blocksBucketName, blockColumns, blockRow
+	case "rounds":
+		bucket, columns, row = roundsBucketName, roundColumns, roundRow
+	default:
+		return usage
+	}
+	from, to := int64(0), int64(^uint32(0)>>1)
+	var err error
+	if len(args) > 1 {
+		from, err = strconv.ParseInt(args[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid height %v: %v", args[1], err)
+		}
+	}
+	if len(args) > 2 {
+		to, err = strconv.ParseInt(args[2], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid height %v: %v", args[2], err)
+		}
+	}
+	if cfg.NoHistory {
+		return fmt.Errorf("the history database is disabled")
+	}
+
+	var writeRow func([]string) error
+	if asCSV {
+		cw := csv.NewWriter(os.Stdout)
+		defer cw.Flush()
+		writeRow = cw.Write
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		defer tw.Flush()
+		writeRow = func(cols []string) error {
+			_, err := fmt.Fprintln(tw, strings.Join(cols, "\t"))
+			return err
+		}
+	}
+
+	if err := writeRow(columns); err != nil {
+		return err
+	}
+	return readHistory(cfg, func(h historyReader) error {
+		return h.forEach(bucket, int32(from), int32(to),
+			func(v []byte) error {
+				cols, err := row(v)
+				if err != nil {
+					return err
+				}
+				return writeRow(cols)
+			})
+	})
+}
+
+// historyFile returns the path of the history database.
+func historyFile(cfg *config) string {
+	return filepath.Join(cfg.DataDir, defaultHistoryFilename)
+}
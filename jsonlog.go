@@ -0,0 +1,229 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btclog"
+)
+
+const (
+	// defaultJSONLogFilename is the name of the JSON-lines log file
+	// written to the log directory.
+	defaultJSONLogFilename = "ticketbuyer.jsonl"
+
+	// jsonLogSuffix is the suffix of a debug level that selects JSON
+	// logging for a subsystem, e.g. TKBY=debug:json.
+	jsonLogSuffix = ":json"
+)
+
+var (
+	// jsonLogFile is the path of the JSON-lines log file. It is opened
+	// the first time a record is written.
+	jsonLogFile string
+
+	jsonLogMtx    sync.Mutex
+	jsonLogWriter *os.File
+)
+
+// levelStrings maps log levels to the names used in JSON log records.
+var levelStrings = map[btclog.LogLevel]string{
+	btclog.TraceLvl:    "trace",
+	btclog.DebugLvl:    "debug",
+	btclog.InfoLvl:     "info",
+	btclog.WarnLvl:     "warn",
+	btclog.ErrorLvl:    "error",
+	btclog.CriticalLvl: "critical",
+}
+
+// jsonLogRecord is a single log message written to the JSON-lines log.
+// Errors carry the machine-readable code of the first error argument of
+// the message.
+type jsonLogRecord struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Subsystem string `json:"subsystem"`
+	Msg       string `json:"msg"`
+	Code      string `json:"code,omitempty"`
+}
+
+// jsonEventRecord is a structured event written to the JSON-lines log.
+type jsonEventRecord struct {
+	Time      string      `json:"time"`
+	Subsystem string      `json:"subsystem"`
+	Event     string      `json:"event"`
+	Data      interface{} `json:"data"`
+}
+
+// initJSONLogger sets the file that JSON log records are written to.
+func initJSONLogger(logFile string) {
+	jsonLogFile = logFile
+}
+
+// writeJSONLine writes a JSON encoded record as a single line to the JSON
+// log file, opening it if needed.
+func writeJSONLine(record interface{}) {
+	b, err := json.Marshal(record)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode JSON log record: %v\n", err)
+		return
+	}
+
+	jsonLogMtx.Lock()
+	defer jsonLogMtx.Unlock()
+
+	if jsonLogWriter == nil {
+		err := os.MkdirAll(filepath.Dir(jsonLogFile), 0700)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create JSON log "+
+				"directory: %v\n", err)
+			return
+		}
+		jsonLogWriter, err = os.OpenFile(jsonLogFile,
+			os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open JSON log file: %v\n",
+				err)
+			return
+		}
+	}
+
+	jsonLogWriter.Write(append(b, '\n'))
+}
+
+// closeJSONLog closes the JSON log file if it is open.
+func closeJSONLog() {
+	jsonLogMtx.Lock()
+	defer jsonLogMtx.Unlock()
+
+	if jsonLogWriter != nil {
+		jsonLogWriter.Close()
+		jsonLogWriter = nil
+	}
+}
+
+// jsonLogger is a subsystem logger that writes each message to the JSON
+// log in addition to the text log of the logger it wraps.
+type jsonLogger struct {
+	btclog.Logger
+	subsystem string
+}
+
+// write writes a JSON log record for a message if the level is enabled.
+func (l *jsonLogger) write(level btclog.LogLevel, format string,
+	params []interface{}) {
+	if level < l.Level() {
+		return
+	}
+
+	record := &jsonLogRecord{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     levelStrings[level],
+		Subsystem: l.subsystem,
+		Msg:       fmt.Sprintf(format, params...),
+	}
+	if level >= btclog.WarnLvl {
+		for _, param := range params {
+			if err, ok := param.(error); ok {
+				record.Code = errorCode(err).String()
+				break
+			}
+		}
+		if record.Code == "" && level >= btclog.ErrorLvl {
+			record.Code = ErrUnknown.String()
+		}
+	}
+
+	writeJSONLine(record)
+}
+
+// Tracef formats a message, writing it to both logs at the trace level.
+func (l *jsonLogger) Tracef(format string, params ...interface{}) {
+	l.Logger.Tracef(format, params...)
+	l.write(btclog.TraceLvl, format, params)
+}
+
+// Debugf formats a message, writing it to both logs at the debug level.
+func (l *jsonLogger) Debugf(format string, params ...interface{}) {
+	l.Logger.Debugf(format, params...)
+	l.write(btclog.DebugLvl, format, params)
+}
+
+// Infof formats a message, writing it to both logs at the info level.
+func (l *jsonLogger) Infof(format string, params ...interface{}) {
+	l.Logger.Infof(format, params...)
+	l.write(btclog.InfoLvl, format, params)
+}
+
+// Warnf formats a message, writing it to both logs at the warn level.
+func (l *jsonLogger) Warnf(format string, params ...interface{}) error {
+	err := l.Logger.Warnf(format, params...)
+	l.write(btclog.WarnLvl, format, params)
+	return err
+}
+
+// Errorf formats a message, writing it to both logs at the error level.
+func (l *jsonLogger) Errorf(format string, params ...interface{}) error {
+	err := l.Logger.Errorf(format, params...)
+	l.write(btclog.ErrorLvl, format, params)
+	return err
+}
+
+// Criticalf formats a message, writing it to both logs at the critical
+// level.
+func (l *jsonLogger) Criticalf(format string, params ...interface{}) error {
+	err := l.Logger.Criticalf(format, params...)
+	l.write(btclog.CriticalLvl, format, params)
+	return err
+}
+
+// parseLogLevel splits a debug level into the log level and whether or
+// not JSON logging is selected with the :json suffix.
+func parseLogLevel(logLevel string) (string, bool) {
+	if strings.HasSuffix(logLevel, jsonLogSuffix) {
+		return strings.TrimSuffix(logLevel, jsonLogSuffix), true
+	}
+	return logLevel, false
+}
+
+// enableJSONLog makes a subsystem write its messages to the JSON log.
+// Invalid subsystems are ignored.
+func enableJSONLog(subsystemID string) {
+	logger, ok := subsystemLoggers[subsystemID]
+	if !ok {
+		return
+	}
+	if _, ok := logger.(*jsonLogger); ok {
+		return
+	}
+	useLogger(subsystemID, &jsonLogger{Logger: logger, subsystem: subsystemID})
+}
+
+// jsonLogEnabled returns whether or not a subsystem writes to the JSON log.
+func jsonLogEnabled(subsystemID string) bool {
+	_, ok := subsystemLoggers[subsystemID].(*jsonLogger)
+	return ok
+}
+
+// logJSONEvent writes a structured event for a subsystem to the JSON log
+// if JSON logging is enabled for it.
+func logJSONEvent(subsystemID, event string, data interface{}) {
+	if !jsonLogEnabled(subsystemID) {
+		return
+	}
+	writeJSONLine(&jsonEventRecord{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Subsystem: subsystemID,
+		Event:     event,
+		Data:      data,
+	})
+}
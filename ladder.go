@@ -0,0 +1,151 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	// queuePenaltyStr is the string indicating that the tickets queued for
+	// a window should decay exponentially with the high price penalty when
+	// the price is above the target price.
+	queuePenaltyStr = "penalty"
+
+	// queueLadderStr is the string indicating that the tickets queued for
+	// a window should be the proportion of the affordable tickets given by
+	// the price band of the price ladder that the price falls in.
+	queueLadderStr = "ladder"
+)
+
+// priceBand is a band of ticket prices in coins, from min inclusive to max
+// exclusive, in which percent of the affordable tickets are bought. A
+// band with a max of zero has no upper bound.
+type priceBand struct {
+	min     float64
+	max     float64
+	percent float64
+}
+
+// String returns the band in the format it is configured in.
+func (b *priceBand) String() string {
+	max := ""
+	if b.max > 0.0 {
+		max = strconv.FormatFloat(b.max, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v-%v:%v", strconv.FormatFloat(b.min, 'f', -1, 64),
+		max, b.percent)
+}
+
+// parseBandNumber parses a price or percentage of a price band, rejecting
+// NaN and infinite values, which no range check would catch.
+func parseBandNumber(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%v is not a finite number", s)
+	}
+	return f, nil
+}
+
+// parsePriceLadder parses a price ladder given as comma separated bands of
+// the form min-max:percent, e.g. 0-80:100,80-100:50,100-:0. The bands must
+// start at 0, be contiguous and in increasing order of price, end with a
+// band without a maximum, and buy no more in a band than in the band below
+// it.
+func parsePriceLadder(ladder string) ([]priceBand, error) {
+	var bands []priceBand
+	for _, s := range strings.Split(ladder, ",") {
+		s = strings.TrimSpace(s)
+		parts := strings.Split(s, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("price band %q is not of the form "+
+				"min-max:percent", s)
+		}
+		bounds := strings.Split(parts[0], "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("price band %q is not of the form "+
+				"min-max:percent", s)
+		}
+		var b priceBand
+		var err error
+		b.min, err = parseBandNumber(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid minimum price in price band "+
+				"%q: %v", s, err)
+		}
+		if bounds[1] != "" {
+			b.max, err = parseBandNumber(bounds[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid maximum price in price "+
+					"band %q: %v", s, err)
+			}
+			if b.max <= b.min {
+				return nil, fmt.Errorf("the maximum price of price band "+
+					"%q must be greater than its minimum", s)
+			}
+		}
+		b.percent, err = parseBandNumber(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid percentage in price band "+
+				"%q: %v", s, err)
+		}
+		if b.percent < 0.0 || b.percent > 100.0 {
+			return nil, fmt.Errorf("the percentage of price band %q must "+
+				"be between 0 and 100", s)
+		}
+		bands = append(bands, b)
+	}
+
+	if bands[0].min != 0.0 {
+		return nil, fmt.Errorf("the first price band must start at 0 "+
+			"(got %v)", bands[0].min)
+	}
+	for i := 1; i < len(bands); i++ {
+		prev, b := &bands[i-1], &bands[i]
+		if prev.max == 0.0 {
+			return nil, fmt.Errorf("only the last price band may be "+
+				"without a maximum price (got %v)", prev)
+		}
+		if b.min != prev.max {
+			return nil, fmt.Errorf("price band %v does not start where "+
+				"price band %v ends", b, prev)
+		}
+		if b.percent > prev.percent {
+			return nil, fmt.Errorf("price band %v buys more than the "+
+				"lower price band %v", b, prev)
+		}
+	}
+	if last := &bands[len(bands)-1]; last.max != 0.0 {
+		return nil, fmt.Errorf("the last price band must be without a "+
+			"maximum price (got %v)", last)
+	}
+
+	return bands, nil
+}
+
+// ladderBand returns the price band that a price falls in.
+func ladderBand(bands []priceBand, price float64) *priceBand {
+	for i := range bands {
+		if bands[i].max == 0.0 || price < bands[i].max {
+			return &bands[i]
+		}
+	}
+	return &bands[len(bands)-1]
+}
+
+// ladderToBuy returns the number of tickets to queue for a window at a
+// price, which is the percentage of the affordable tickets given by the
+// price band that the price falls in.
+func ladderToBuy(bands []priceBand, price, couldBuy float64) (int,
+	*priceBand) {
+	band := ladderBand(bands, price)
+	return int(math.Floor(couldBuy * band.percent / 100.0)), band
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParsePriceLadder ensures that price ladders are parsed into their
+// bands and that ladders whose bands are malformed, not contiguous, buy
+// more at higher prices or hold numbers that are not finite are rejected.
+func TestParsePriceLadder(t *testing.T) {
+	tests := []struct {
+		name    string
+		ladder  string
+		want    []priceBand
+		wantErr bool
+	}{
+		{
+			name:   "single band",
+			ladder: "0-:100",
+			want:   []priceBand{{0, 0, 100}},
+		},
+		{
+			name:   "three bands",
+			ladder: "0-80:100, 80-100:50, 100-:0",
+			want:   []priceBand{{0, 80, 100}, {80, 100, 50}, {100, 0, 0}},
+		},
+		{
+			name:   "equal percentages",
+			ladder: "0-50.5:25,50.5-:25",
+			want:   []priceBand{{0, 50.5, 25}, {50.5, 0, 25}},
+		},
+		{name: "empty", ladder: "", wantErr: true},
+		{name: "missing percentage", ladder: "0-80,80-:0", wantErr: true},
+		{name: "missing bounds", ladder: "0:100", wantErr: true},
+		{name: "not starting at 0", ladder: "10-:100", wantErr: true},
+		{name: "gap", ladder: "0-80:100,90-:50", wantErr: true},
+		{name: "overlap", ladder: "0-80:100,70-:50", wantErr: true},
+		{name: "decreasing prices", ladder: "0-80:100,80-40:50,40-:0",
+			wantErr: true},
+		{name: "empty band", ladder: "0-80:100,80-80:50,80-:0",
+			wantErr: true},
+		{name: "buys more at higher prices", ladder: "0-80:50,80-:100",
+			wantErr: true},
+		{name: "last band bounded", ladder: "0-80:100,80-100:50",
+			wantErr: true},
+		{name: "unbounded band before the last", ladder: "0-:100,80-:50",
+			wantErr: true},
+		{name: "percentage above 100", ladder: "0-:101", wantErr: true},
+		{name: "negative percentage", ladder: "0-80:100,80-:-1",
+			wantErr: true},
+		{name: "NaN minimum", ladder: "NaN-:100", wantErr: true},
+		{name: "NaN maximum", ladder: "0-NaN:100,NaN-:0", wantErr: true},
+		{name: "NaN percentage", ladder: "0-80:100,80-:NaN",
+			wantErr: true},
+		{name: "infinite maximum", ladder: "0-Inf:100", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := parsePriceLadder(test.ladder)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: got bands %v, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got bands %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestLadderToBuy ensures the percentage of the band a price falls in is
+// bought, with band minimums inclusive and maximums exclusive.
+func TestLadderToBuy(t *testing.T) {
+	bands, err := parsePriceLadder("0-80:100,80-100:50,100-:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		price    float64
+		couldBuy float64
+		want     int
+	}{
+		{50, 10, 10},
+		{79.99, 10, 10},
+		{80, 10, 5},
+		{90, 7, 3},
+		{100, 10, 0},
+		{1000, 10, 0},
+	}
+
+	for _, test := range tests {
+		got, _ := ladderToBuy(bands, test.price, test.couldBuy)
+		if got != test.want {
+			t.Errorf("price %v of %v tickets: got %v, want %v", test.price,
+				test.couldBuy, got, test.want)
+		}
+	}
+}
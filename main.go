@@ -7,8 +7,10 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/decred/dcrd/chaincfg/chainhash"
@@ -22,19 +24,166 @@ const (
 
 func main() {
 	// Parse the configuration file.
-	cfg, err := loadConfig()
+	cfg, args, err := loadConfig()
 	if err != nil {
 		fmt.Printf("Failed to load ticketbuyer config: %s\n", err.Error())
 		os.Exit(1)
 	}
 	defer backendLog.Flush()
 
+	// Run a command against the running ticket buyer instead of
+	// starting a new one if one was given.
+	if len(args) > 0 {
+		if err := runCommand(cfg, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			backendLog.Flush()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Seed the random jitter used in purchase pacing.
+	rand.Seed(time.Now().UnixNano())
+
 	dcrrpcclient.UseLogger(clientLog)
 
+	// Read the RPC passwords that are not set in the config, and the
+	// wallet passphrase if the wallet is unlocked automatically.
+	walletPass, err := loadCredentials(cfg)
+	if err != nil {
+		fmt.Printf("Failed to load RPC credentials: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	// Track tickets with dcrd alone instead of purchasing them in
+	// watch-only mode.
+	if cfg.WatchOnly {
+		if err := runWatchOnly(cfg); err != nil {
+			fmt.Printf("%s\n", err.Error())
+			backendLog.Flush()
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Connect to dcrd RPC server using websockets. Set up the
 	// notification handler to deliver blocks through a channel.
 	connectChan := make(chan int32, blockConnChanBuffer)
 	quit := make(chan struct{})
+	dcrdClient, err := connectDaemon(cfg, connectChan)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	// Connect to the dcrwallet server RPC client.
+	dcrwClient, err := connectWallet(cfg)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	// Refuse to start if the servers are incompatible.
+	if errs := checkCompatibility(cfg, dcrdClient, dcrwClient); len(errs) != 0 {
+		fmt.Printf("Incompatible RPC servers:\n")
+		for _, err := range errs {
+			fmt.Printf("  %s\n", err.Error())
+		}
+		dcrdClient.Disconnect()
+		dcrwClient.Disconnect()
+		os.Exit(1)
+	}
+
+	purchaser, err := newTicketPurchaser(cfg, dcrdClient, dcrwClient,
+		walletPass)
+	if err != nil {
+		fmt.Printf("Failed to start purchaser: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if purchaser.splitCoordinator != nil {
+		purchaser.splitCoordinator.start()
+	}
+
+	manualPurchaseChan := make(chan *manualPurchase)
+	if cfg.ControlListen != "" {
+		newControlServer(cfg.ControlListen, cfg.ControlToken,
+			manualPurchaseChan, purchaser.history, quit).start()
+	}
+
+	// Render the dashboard in place of the console log if requested.
+	var dash *dashboard
+	if cfg.TUI {
+		dash = newDashboard(cfg, os.Stdout)
+		dash.render()
+	}
+
+	// Shut down cleanly on Ctrl-C or when the service is stopped.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	if cfg.PIDFile != "" {
+		if err := writePIDFile(cfg.PIDFile); err != nil {
+			fmt.Printf("Failed to write PID file: %s\n", err.Error())
+			os.Exit(1)
+		}
+		defer removePIDFile(cfg.PIDFile)
+	}
+
+	wsm := newPurchaseManager(purchaser, connectChan, manualPurchaseChan,
+		dash, quit)
+	handlerDone := make(chan struct{})
+	go func() {
+		wsm.blockConnectedHandler()
+		close(handlerDone)
+	}()
+
+	log.Infof("Daemon and wallet successfully connected, beginning " +
+		"to purchase tickets")
+	sdNotify("READY=1")
+
+	sig := <-interrupt
+	log.Infof("Received signal (%s), shutting down", sig)
+	sdNotify("STOPPING=1")
+
+	// Let a purchase round that is in progress finish before
+	// disconnecting from the RPC servers.
+	close(quit)
+	<-handlerDone
+	if purchaser.history != nil {
+		if err := purchaser.history.close(); err != nil {
+			log.Errorf("Failed to close the history database: %v", err)
+		}
+	}
+	dcrdClient.Disconnect()
+	dcrwClient.Disconnect()
+	closeJSONLog()
+	if !cfg.TUI {
+		fmt.Printf("\nClosing ticket buyer.\n")
+	}
+}
+
+// runCommand runs the command named by the first argument.
+func runCommand(cfg *config, args []string) error {
+	switch args[0] {
+	case "buy":
+		return runBuyCommand(cfg, args[1:])
+	case "createsecrets":
+		return runCreateSecretsCommand(cfg, args[1:])
+	case "query":
+		return runQueryCommand(cfg, args[1:])
+	case "explain":
+		return runExplainCommand(cfg, args[1:])
+	}
+
+	return fmt.Errorf("unknown command %v", args[0])
+}
+
+// connectDaemon connects to the dcrd RPC server using websockets and
+// registers for block connected notifications, which are delivered
+// through connectChan.
+func connectDaemon(cfg *config, connectChan chan int32) (*dcrrpcclient.Client,
+	error) {
 	ntfnHandlersDaemon := dcrrpcclient.NotificationHandlers{
 		OnBlockConnected: func(hash *chainhash.Hash, height int32,
 			time time.Time, vb uint16) {
@@ -43,12 +192,12 @@ func main() {
 	}
 
 	var dcrdCerts []byte
+	var err error
 	if !cfg.DisableClientTLS {
 		dcrdCerts, err = ioutil.ReadFile(cfg.DcrdCert)
 		if err != nil {
-			fmt.Printf("Failed to read dcrd cert file at %s: %s\n", cfg.DcrdCert,
-				err.Error())
-			os.Exit(1)
+			return nil, fmt.Errorf("Failed to read dcrd cert file at %s: %s",
+				cfg.DcrdCert, err.Error())
 		}
 	}
 	log.Debugf("Attempting to connect to dcrd RPC %s as user %s "+
@@ -64,24 +213,29 @@ func main() {
 	}
 	dcrdClient, err := dcrrpcclient.New(connCfgDaemon, &ntfnHandlersDaemon)
 	if err != nil {
-		fmt.Printf("Failed to start dcrd rpcclient: %s\n", err.Error())
-		os.Exit(1)
+		return nil, fmt.Errorf("Failed to start dcrd rpcclient: %s",
+			err.Error())
 	}
 
 	// Register for block connection notifications.
 	if err := dcrdClient.NotifyBlocks(); err != nil {
-		fmt.Printf("Failed to start register daemon rpc client for  "+
-			"block notifications: %s\n", err.Error())
-		os.Exit(1)
+		dcrdClient.Disconnect()
+		return nil, fmt.Errorf("Failed to start register daemon rpc "+
+			"client for block notifications: %s", err.Error())
 	}
 
-	// Connect to the dcrwallet server RPC client.
+	return dcrdClient, nil
+}
+
+// connectWallet connects to the dcrwallet RPC server using websockets.
+func connectWallet(cfg *config) (*dcrrpcclient.Client, error) {
 	var dcrwCerts []byte
+	var err error
 	if !cfg.DisableClientTLS {
 		dcrwCerts, err = ioutil.ReadFile(cfg.DcrwCert)
 		if err != nil {
-			fmt.Printf("Failed to read dcrwallet cert file at %s: %s\n",
-				cfg.DcrwCert, err.Error())
+			return nil, fmt.Errorf("Failed to read dcrwallet cert file at "+
+				"%s: %s", cfg.DcrwCert, err.Error())
 		}
 	}
 	connCfgWallet := &dcrrpcclient.ConnConfig{
@@ -97,35 +251,9 @@ func main() {
 		cfg.DcrwServ, cfg.DcrwUser, cfg.DcrwCert)
 	dcrwClient, err := dcrrpcclient.New(connCfgWallet, nil)
 	if err != nil {
-		fmt.Printf("Failed to start dcrd rpcclient: %s\n", err.Error())
-		os.Exit(1)
+		return nil, fmt.Errorf("Failed to start dcrwallet rpcclient: %s",
+			err.Error())
 	}
 
-	// Ctrl-C to kill.
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		for range c {
-			quit <- struct{}{}
-		}
-	}()
-
-	purchaser, err := newTicketPurchaser(cfg, dcrdClient, dcrwClient)
-	if err != nil {
-		fmt.Printf("Failed to start purchaser: %s\n", err.Error())
-		os.Exit(1)
-	}
-
-	wsm := newPurchaseManager(purchaser, connectChan, quit)
-	go wsm.blockConnectedHandler()
-
-	log.Infof("Daemon and wallet successfully connected, beginning " +
-		"to purchase tickets")
-
-	<-quit
-	close(quit)
-	dcrdClient.Disconnect()
-	dcrwClient.Disconnect()
-	fmt.Printf("\nClosing ticket buyer.\n")
-	os.Exit(1)
+	return dcrwClient, nil
 }
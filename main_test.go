@@ -0,0 +1,175 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cjepson/dcrticketbuyer/rpctest"
+	"github.com/decred/dcrrpcclient"
+)
+
+// testTimeout is how long tests wait for the ticket buyer to react to a
+// connected block.
+const testTimeout = 5 * time.Second
+
+// testBuyer is a ticket buyer connected to the fakes of a test harness,
+// with its purchase manager running on its own goroutine.
+type testBuyer struct {
+	harness    *rpctest.Harness
+	purchaser  *ticketPurchaser
+	dcrdClient *dcrrpcclient.Client
+	dcrwClient *dcrrpcclient.Client
+	blocks     chan int32
+	manual     chan *manualPurchase
+	quit       chan struct{}
+	done       chan struct{}
+}
+
+// newTestHarness starts a test harness with a chain of the given height,
+// with an average ticket price of 20 coins, a stake difficulty of 10 coins,
+// a next window estimate of 15 coins and a spendable balance of 100 coins.
+func newTestHarness(t *testing.T, height int64) *rpctest.Harness {
+	h, err := rpctest.New(activeNet.Params, height)
+	if err != nil {
+		t.Fatalf("failed to start harness: %v", err)
+	}
+	poolSize := float64(activeNet.TicketsPerBlock) *
+		float64(activeNet.TicketPoolSize)
+	h.Dcrd.SetTicketVWAP(20)
+	h.Dcrd.SetTicketPoolValue(20 * poolSize)
+	h.Dcrd.SetStakeDifficulty(10, 10)
+	h.Dcrd.SetStakeDiffEstimate(10, 20, 15)
+	h.Dcrd.SetTicketFee(0.01)
+	h.Wallet.SetBalance(100)
+	return h
+}
+
+// newTestConfig returns the default configuration pointed at the fakes of
+// a test harness, without a history database.
+func newTestConfig(h *rpctest.Harness) *config {
+	cfg := defaultConfig()
+	cfg.DcrdServ = h.DcrdAddr()
+	cfg.DcrwServ = h.WalletAddr()
+	cfg.DisableClientTLS = true
+	cfg.NoHistory = true
+	return &cfg
+}
+
+// startTestBuyer connects a ticket buyer configured by cfg to the fakes of
+// a test harness and starts its purchase manager. If notified is true, the
+// purchase manager handles the block connected notifications of the fake
+// daemon, otherwise it only handles the blocks passed to connectBlock.
+func startTestBuyer(t *testing.T, h *rpctest.Harness, cfg *config,
+	walletPass []byte, notified bool) *testBuyer {
+	connectChan := make(chan int32, blockConnChanBuffer)
+	dcrdClient, err := connectDaemon(cfg, connectChan)
+	if err != nil {
+		t.Fatalf("failed to connect to the fake dcrd: %v", err)
+	}
+	dcrwClient, err := connectWallet(cfg)
+	if err != nil {
+		dcrdClient.Shutdown()
+		t.Fatalf("failed to connect to the fake dcrwallet: %v", err)
+	}
+	b := &testBuyer{
+		harness:    h,
+		dcrdClient: dcrdClient,
+		dcrwClient: dcrwClient,
+		blocks:     make(chan int32),
+		manual:     make(chan *manualPurchase),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if errs := checkCompatibility(cfg, dcrdClient, dcrwClient); len(errs) != 0 {
+		b.shutdown()
+		t.Fatalf("fakes are incompatible: %v", errs)
+	}
+	b.purchaser, err = newTicketPurchaser(cfg, dcrdClient, dcrwClient,
+		walletPass)
+	if err != nil {
+		b.shutdown()
+		t.Fatalf("failed to create purchaser: %v", err)
+	}
+
+	blocks := b.blocks
+	if notified {
+		blocks = connectChan
+	} else {
+		// Drain the notifications so that they never block the client.
+		go func() {
+			for {
+				select {
+				case <-connectChan:
+				case <-b.quit:
+					return
+				}
+			}
+		}()
+	}
+	wsm := newPurchaseManager(b.purchaser, blocks, b.manual, nil, b.quit)
+	go func() {
+		wsm.blockConnectedHandler()
+		close(b.done)
+	}()
+
+	return b
+}
+
+// connectBlock connects a block on the fake daemon and passes its height
+// to the purchase manager. It returns once the purchase manager has
+// finished handling the block.
+func (b *testBuyer) connectBlock() int32 {
+	height := int32(b.harness.ConnectBlock())
+	b.blocks <- height
+	b.purchase(0)
+	return height
+}
+
+// purchase requests the manual purchase of count tickets from the purchase
+// manager and returns its response. Since the purchase manager handles
+// one request at a time, a request for no tickets, which always fails
+// without any RPCs, waits for the block being handled to be finished.
+func (b *testBuyer) purchase(count int) *controlPurchaseResponse {
+	mp := &manualPurchase{
+		request: &controlPurchaseRequest{Count: count},
+		reply:   make(chan *controlPurchaseResponse, 1),
+	}
+	b.manual <- mp
+	return <-mp.reply
+}
+
+// stop stops the purchase manager, the ticket buyer and the harness.
+func (b *testBuyer) stop() {
+	close(b.quit)
+	<-b.done
+	b.shutdown()
+}
+
+// shutdown disconnects the RPC clients and stops the harness.
+func (b *testBuyer) shutdown() {
+	b.dcrdClient.Shutdown()
+	b.dcrwClient.Shutdown()
+	b.harness.Close()
+}
+
+// TestBlockConnectedNotification ensures that a block connected
+// notification from the daemon starts a purchase round.
+func TestBlockConnectedNotification(t *testing.T) {
+	h := newTestHarness(t, 300)
+	b := startTestBuyer(t, h, newTestConfig(h), nil, true)
+	defer b.stop()
+
+	h.ConnectBlock()
+	if err := h.WaitForCalls("purchaseticket", 1, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	purchases := h.Wallet.Purchases()
+	if len(purchases) != 1 || purchases[0].NumTickets != defaultMaxPerBlock {
+		t.Fatalf("got purchases %+v, want one of %v tickets", purchases,
+			defaultMaxPerBlock)
+	}
+}
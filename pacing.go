@@ -0,0 +1,91 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+var (
+	// pacingGreedyStr is the string indicating that tickets in the queue
+	// should be purchased as quickly as maxperblock allows.
+	pacingGreedyStr = "greedy"
+
+	// pacingEvenStr is the string indicating that tickets in the queue
+	// should be spread evenly across the blocks of the window.
+	pacingEvenStr = "even"
+)
+
+// blocksLeftInWindow returns the number of blocks, including the current
+// one, that tickets may still be purchased in for the current window's
+// queue. The queue is filled at the last block of the previous window,
+// so purchases made at that block count towards the window as well.
+func blocksLeftInWindow(idxDiffPeriod int) int {
+	winSize := int(activeNet.StakeDiffWindowSize)
+	if idxDiffPeriod == winSize-1 {
+		return winSize
+	}
+	return winSize - 1 - idxDiffPeriod
+}
+
+// eligibleBlocks returns the number of blocks from height among the next
+// blocks that tickets may be purchased in when they are only purchased at
+// heights that are a multiple of every.
+func eligibleBlocks(height int32, blocks, every int) int {
+	if every <= 1 {
+		return blocks
+	}
+	first := int(height) + (every-int(height)%every)%every
+	last := int(height) + blocks - 1
+	if first > last {
+		return 0
+	}
+	return (last-first)/every + 1
+}
+
+// pacedToBuy returns the number of tickets to purchase in the block at
+// height so that the tickets queued for the window are spread evenly
+// across all of its blocks. The number of tickets that should have been
+// purchased by the end of this block is calculated from the fraction of
+// the window that has elapsed, optionally randomized by up to jitter
+// proportionally. Enough tickets are always purchased to be able to finish
+// the queue before the window ends at maxPerBlock tickets in each of the
+// remaining blocks that tickets may be purchased in, which are only those
+// at heights that are a multiple of every.
+func pacedToBuy(toBuyDiffPeriod, purchasedDiffPeriod int, height int32,
+	every, maxPerBlock int, jitter float64) int {
+	winSize := int(activeNet.StakeDiffWindowSize)
+	blocksLeft := blocksLeftInWindow(int(height) % winSize)
+	remaining := toBuyDiffPeriod - purchasedDiffPeriod
+	if remaining <= 0 {
+		return 0
+	}
+
+	elapsed := float64(winSize-blocksLeft+1) / float64(winSize)
+	if jitter > 0.0 {
+		elapsed *= 1.0 + jitter*(2.0*rand.Float64()-1.0)
+	}
+	scheduled := int(math.Ceil(float64(toBuyDiffPeriod) * elapsed))
+	if scheduled > toBuyDiffPeriod {
+		scheduled = toBuyDiffPeriod
+	}
+	toBuy := scheduled - purchasedDiffPeriod
+
+	// Never fall so far behind that the queue can't be finished.
+	eligibleLeft := eligibleBlocks(height, blocksLeft, every)
+	mustBuy := remaining - (eligibleLeft-1)*maxPerBlock
+	if toBuy < mustBuy {
+		toBuy = mustBuy
+	}
+	if toBuy > maxPerBlock {
+		toBuy = maxPerBlock
+	}
+	if toBuy < 0 {
+		toBuy = 0
+	}
+
+	return toBuy
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+// TestBlocksLeftInWindow ensures the blocks left to purchase the window's
+// queue in include the last block of the previous window.
+func TestBlocksLeftInWindow(t *testing.T) {
+	winSize := int(activeNet.StakeDiffWindowSize)
+	tests := []struct {
+		idx  int
+		want int
+	}{
+		{winSize - 1, winSize},
+		{0, winSize - 1},
+		{winSize / 2, winSize - 1 - winSize/2},
+		{winSize - 2, 1},
+	}
+
+	for _, test := range tests {
+		got := blocksLeftInWindow(test.idx)
+		if got != test.want {
+			t.Errorf("index %v: got %v, want %v", test.idx, got, test.want)
+		}
+	}
+}
+
+// TestEligibleBlocks ensures only the blocks at heights that are a
+// multiple of the purchase frequency are counted.
+func TestEligibleBlocks(t *testing.T) {
+	tests := []struct {
+		height int32
+		blocks int
+		every  int
+		want   int
+	}{
+		{100, 10, 1, 10},
+		{100, 10, 0, 10},
+		{100, 10, 4, 3},
+		{100, 9, 4, 3},
+		{100, 8, 4, 2},
+		{101, 3, 4, 0},
+		{101, 4, 4, 1},
+		{100, 1, 4, 1},
+	}
+
+	for _, test := range tests {
+		got := eligibleBlocks(test.height, test.blocks, test.every)
+		if got != test.want {
+			t.Errorf("%v blocks from height %v every %v: got %v, want %v",
+				test.blocks, test.height, test.every, got, test.want)
+		}
+	}
+}
+
+// TestPacedToBuy ensures the queue is spread across the window and always
+// finished by its end. The expected values assume the mainnet window size
+// of 144 blocks.
+func TestPacedToBuy(t *testing.T) {
+	winSize := int32(activeNet.StakeDiffWindowSize)
+	if winSize != 144 {
+		t.Skipf("test assumes a window size of 144, got %v", winSize)
+	}
+	tests := []struct {
+		name        string
+		toBuy       int
+		purchased   int
+		height      int32
+		every       int
+		maxPerBlock int
+		want        int
+	}{
+		{"queue finished", 10, 10, winSize + 50, 1, 3, 0},
+		{"queue fill block", 100, 0, winSize - 1, 1, 3, 1},
+		{"first block", 100, 1, winSize, 1, 3, 1},
+		{"ahead of schedule", 144, 10, winSize + 1, 1, 3, 0},
+		{"last block", 10, 4, 2*winSize - 2, 1, 3, 3},
+		{"last block remainder", 10, 8, 2*winSize - 2, 1, 3, 2},
+		{"behind schedule", 100, 0, 2*winSize - 30, 1, 5, 5},
+		{"behind near the end", 30, 10, 2*winSize - 5, 1, 5, 5},
+		{"must finish every 4", 37, 2, winSize + 4, 4, 1, 1},
+		{"ahead every 4", 36, 2, winSize + 4, 4, 1, 0},
+	}
+
+	for _, test := range tests {
+		got := pacedToBuy(test.toBuy, test.purchased, test.height,
+			test.every, test.maxPerBlock, 0)
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+
+	"github.com/decred/dcrutil"
+)
+
+// ticketSizeEstimate is the estimated serialized size in bytes of a ticket
+// purchased by the wallet, with one input and a change output. It is used
+// to size pre-split outputs to pay for the fee of a ticket.
+const ticketSizeEstimate = 300
+
+// estimatedTicketFee returns the estimated fee of a ticket at the last
+// ticket fee per KB set in the wallet, or at minfee before one is set.
+func (t *ticketPurchaser) estimatedTicketFee() (dcrutil.Amount, error) {
+	ticketFee := t.ticketFee
+	if ticketFee == 0 {
+		var err error
+		ticketFee, err = dcrutil.NewAmount(t.cfg.MinFee)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return dcrutil.Amount(math.Ceil(float64(ticketFee) *
+		ticketSizeEstimate / 1000.0)), nil
+}
+
+// preSplit creates outputs in the account, each sized exactly for one
+// ticket at the next window's stake difficulty plus its fee. It runs at
+// the last block of a window, where the next block's stake difficulty is
+// the next window's, before the purchase round that begins buying for that
+// window. Each ticket purchased for that window may then spend an output
+// of its own instead of chaining off the unconfirmed change of the
+// previous ticket. One output is created for each ticket the next window's
+// budget affords, up to presplitoutputs.
+func (t *ticketPurchaser) preSplit(height int32) error {
+	if t.cfg.PreSplitOutputs <= 0 {
+		return nil
+	}
+	winSize := int32(activeNet.StakeDiffWindowSize)
+	if (height+1)%winSize != 0 {
+		return nil
+	}
+
+	stakeDiffs, err := t.dcrdChainSvr.GetStakeDifficulty()
+	if err != nil {
+		return err
+	}
+	price, err := dcrutil.NewAmount(stakeDiffs.NextStakeDifficulty)
+	if err != nil {
+		return err
+	}
+	maxPriceAbsAmt, err := dcrutil.NewAmount(t.cfg.MaxPriceAbsolute)
+	if err != nil {
+		return err
+	}
+	if price > maxPriceAbsAmt {
+		log.Debugf("Not pre-splitting outputs because the next window's "+
+			"stake difficulty %v is above the maximum absolute price %v",
+			price, maxPriceAbsAmt)
+		return nil
+	}
+
+	fee, err := t.estimatedTicketFee()
+	if err != nil {
+		return err
+	}
+	outputAmt := price + fee
+
+	// Create one output for each ticket the budget of the window whose
+	// queue is filled at this block affords, and fewer if all of them
+	// would take the balance below the balance to maintain.
+	balSpendable, err := t.dcrwChainSvr.GetBalanceMinConfType(t.cfg.AccountName,
+		1, "spendable")
+	if err != nil {
+		return err
+	}
+	budget, _, err := t.windowBudget(height, balSpendable)
+	if err != nil {
+		return err
+	}
+	numOutputs := int(budget / outputAmt)
+	if numOutputs > t.cfg.PreSplitOutputs {
+		numOutputs = t.cfg.PreSplitOutputs
+	}
+	for numOutputs > 0 && balSpendable.ToCoin()-float64(numOutputs)*
+		outputAmt.ToCoin() < t.cfg.BalanceToMaintain {
+		numOutputs--
+	}
+	if numOutputs == 0 {
+		log.Debugf("Not pre-splitting outputs because the budget %v of "+
+			"the next window and the spendable balance %v are too low to "+
+			"create an output of %v", budget, balSpendable, outputAmt)
+		return nil
+	}
+
+	amounts := make(map[dcrutil.Address]dcrutil.Amount, numOutputs)
+	for i := 0; i < numOutputs; i++ {
+		addr, err := t.dcrwChainSvr.GetNewAddress(t.cfg.AccountName)
+		if err != nil {
+			return err
+		}
+		amounts[addr] = outputAmt
+	}
+	var txHash string
+	err = t.withUnlockedWallet(func() error {
+		hash, err := t.dcrwChainSvr.SendMany(t.cfg.AccountName, amounts)
+		if err != nil {
+			return err
+		}
+		txHash = hash.String()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Pre-split %v %s of %v for the next window at the stake "+
+		"difficulty %v in transaction %v", numOutputs,
+		pickNoun(numOutputs, "output", "outputs"), outputAmt, price, txHash)
+
+	return nil
+}
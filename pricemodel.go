@@ -0,0 +1,192 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrutil"
+)
+
+var (
+	// avgPriceVWAPStr is the string indicating that the VWAP should be
+	// used as the average ticket price.
+	avgPriceVWAPStr = "vwap"
+
+	// avgPricePoolStr is the string indicating that the mean price of
+	// tickets in the ticket pool should be used as the average ticket
+	// price.
+	avgPricePoolStr = "pool"
+
+	// avgPriceDualStr is the string indicating that a weighted blend of
+	// the VWAP and the ticket pool mean price should be used as the
+	// average ticket price.
+	avgPriceDualStr = "dual"
+
+	// avgPriceMedianStr is the string indicating that the median of the
+	// stake difficulties of recent windows should be used as the average
+	// ticket price.
+	avgPriceMedianStr = "median"
+
+	// avgPriceFileStr is the string indicating that the average ticket
+	// price should be read from an external price file.
+	avgPriceFileStr = "file"
+)
+
+// ticketVWAP returns the VWAP of tickets over the configured height range,
+// or the daemon default range if none is configured.
+func (t *ticketPurchaser) ticketVWAP(height int32) (dcrutil.Amount, error) {
+	if t.cfg.AvgPriceVWAPDelta <= 0 {
+		return t.dcrdChainSvr.TicketVWAP(nil, nil)
+	}
+
+	start := uint32(0)
+	if int(height) > t.cfg.AvgPriceVWAPDelta {
+		start = uint32(int(height) - t.cfg.AvgPriceVWAPDelta)
+	}
+	end := uint32(height)
+	return t.dcrdChainSvr.TicketVWAP(&start, &end)
+}
+
+// ticketPoolMean returns the mean price of all tickets in the ticket pool.
+func (t *ticketPurchaser) ticketPoolMean() (dcrutil.Amount, error) {
+	poolValue, err := t.dcrdChainSvr.GetTicketPoolValue()
+	if err != nil {
+		return 0, err
+	}
+	bestBlockH, err := t.dcrdChainSvr.GetBestBlockHash()
+	if err != nil {
+		return 0, err
+	}
+	bestBlock, err := t.dcrdChainSvr.GetBlock(bestBlockH)
+	if err != nil {
+		return 0, err
+	}
+	poolSize := bestBlock.MsgBlock().Header.PoolSize
+
+	// Do not allow zero pool sizes to prevent a possible
+	// panic below.
+	if poolSize == 0 {
+		poolSize++
+	}
+
+	log.Debugf("Ticket pool value %v, pool size %v", poolValue, poolSize)
+
+	return poolValue / dcrutil.Amount(poolSize), nil
+}
+
+// windowsMedianDiff returns the median of the stake difficulties of the
+// last AvgPriceWindows many windows, including the current one.
+func (t *ticketPurchaser) windowsMedianDiff(height int32) (dcrutil.Amount,
+	error) {
+	winSize := int64(activeNet.StakeDiffWindowSize)
+	windowStart := (int64(height) / winSize) * winSize
+	diffs := make([]float64, 0, t.cfg.AvgPriceWindows)
+	for i := 0; i < t.cfg.AvgPriceWindows; i++ {
+		start := windowStart - int64(i)*winSize
+		if start < 0 {
+			break
+		}
+		blH, err := t.dcrdChainSvr.GetBlockHash(start)
+		if err != nil {
+			return 0, err
+		}
+		bl, err := t.dcrdChainSvr.GetBlock(blH)
+		if err != nil {
+			return 0, err
+		}
+		diffs = append(diffs, float64(bl.MsgBlock().Header.SBits))
+	}
+	if len(diffs) == 0 {
+		return 0, fmt.Errorf("no windows available to find the median " +
+			"stake difficulty")
+	}
+
+	sort.Float64s(diffs)
+	median := diffs[len(diffs)/2]
+	if len(diffs)%2 == 0 {
+		median = (diffs[len(diffs)/2-1] + diffs[len(diffs)/2]) / 2
+	}
+
+	log.Debugf("Stake difficulties of the last %v windows: %v",
+		len(diffs), newLogClosure(func() string {
+			amts := make([]string, len(diffs))
+			for i := range diffs {
+				amts[i] = dcrutil.Amount(diffs[i]).String()
+			}
+			return strings.Join(amts, ", ")
+		}))
+
+	return dcrutil.Amount(median), nil
+}
+
+// filePrice reads the average ticket price in coins from the configured
+// price file. The file is read every round so that it may be updated
+// externally while the ticket buyer is running.
+func (t *ticketPurchaser) filePrice() (dcrutil.Amount, error) {
+	b, err := ioutil.ReadFile(t.cfg.AvgPriceFile)
+	if err != nil {
+		return 0, err
+	}
+	price, err := strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse price file %s: %v",
+			t.cfg.AvgPriceFile, err)
+	}
+
+	log.Debugf("Read price %v from price file %s", price, t.cfg.AvgPriceFile)
+
+	return dcrutil.NewAmount(price)
+}
+
+// calcAverageTicketPrice calculates the "reasonable" ticket price used as
+// the reference for the target price and the scaled minimum and maximum
+// prices, according to the selected average price model.
+func (t *ticketPurchaser) calcAverageTicketPrice(height int32) (dcrutil.Amount,
+	error) {
+	switch t.cfg.AvgPriceMode {
+	case avgPriceVWAPStr:
+		ticketVWAP, err := t.ticketVWAP(height)
+		if err != nil {
+			return 0, err
+		}
+		log.Debugf("Ticket VWAP: %v", ticketVWAP)
+		return ticketVWAP, nil
+
+	case avgPricePoolStr:
+		return t.ticketPoolMean()
+
+	case avgPriceMedianStr:
+		return t.windowsMedianDiff(height)
+
+	case avgPriceFileStr:
+		return t.filePrice()
+	}
+
+	// Use a weighted blend of the VWAP and the ticket pool mean.
+	ticketVWAP, err := t.ticketVWAP(height)
+	if err != nil {
+		return 0, err
+	}
+	avgPricePoolAmt, err := t.ticketPoolMean()
+	if err != nil {
+		return 0, err
+	}
+	vwapWeight := t.cfg.AvgPriceVWAPWeight
+	poolWeight := t.cfg.AvgPricePoolWeight
+	if vwapWeight+poolWeight <= 0 {
+		return 0, fmt.Errorf("the VWAP and pool weights must sum to a " +
+			"positive number")
+	}
+	log.Debugf("Ticket VWAP %v (weight %v), ticket pool mean price %v "+
+		"(weight %v)", ticketVWAP, vwapWeight, avgPricePoolAmt, poolWeight)
+
+	return dcrutil.Amount((float64(ticketVWAP)*vwapWeight +
+		float64(avgPricePoolAmt)*poolWeight) / (vwapWeight + poolWeight)), nil
+}
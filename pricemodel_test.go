@@ -0,0 +1,135 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCalcAverageTicketPrice ensures the average ticket price is found
+// with each average price model. The VWAP is 20 coins and the mean price
+// of the tickets in the pool is 30 coins.
+func TestCalcAverageTicketPrice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pricemodel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	priceFile := filepath.Join(dir, "price")
+	if err := ioutil.WriteFile(priceFile, []byte("42.5\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	badPriceFile := filepath.Join(dir, "badprice")
+	if err := ioutil.WriteFile(badPriceFile, []byte("cheap"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		mode       string
+		vwapWeight float64
+		poolWeight float64
+		file       string
+		want       float64
+		wantErr    bool
+	}{
+		{name: "vwap", mode: avgPriceVWAPStr, want: 20},
+		{name: "pool", mode: avgPricePoolStr, want: 30},
+		{name: "dual", mode: avgPriceDualStr, vwapWeight: 1, poolWeight: 1,
+			want: 25},
+		{name: "dual weighted", mode: avgPriceDualStr, vwapWeight: 3,
+			poolWeight: 1, want: 22.5},
+		{name: "dual without weights", mode: avgPriceDualStr,
+			wantErr: true},
+		{name: "file", mode: avgPriceFileStr, file: priceFile, want: 42.5},
+		{name: "unparsable file", mode: avgPriceFileStr, file: badPriceFile,
+			wantErr: true},
+		{name: "missing file", mode: avgPriceFileStr,
+			file: filepath.Join(dir, "missing"), wantErr: true},
+	}
+
+	h := newTestHarness(t, 300)
+	poolSize := float64(activeNet.TicketsPerBlock) *
+		float64(activeNet.TicketPoolSize)
+	h.Dcrd.SetTicketPoolValue(30 * poolSize)
+	cfg := newTestConfig(h)
+	b := startTestBuyer(t, h, cfg, nil, false)
+	defer b.stop()
+
+	for _, test := range tests {
+		cfg.AvgPriceMode = test.mode
+		cfg.AvgPriceVWAPWeight = test.vwapWeight
+		cfg.AvgPricePoolWeight = test.poolWeight
+		cfg.AvgPriceFile = test.file
+		got, err := b.purchaser.calcAverageTicketPrice(300)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: got price %v, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if got.ToCoin() != test.want {
+			t.Errorf("%s: got price %v, want %v", test.name, got.ToCoin(),
+				test.want)
+		}
+	}
+}
+
+// TestWindowsMedianDiff ensures the median of the stake difficulties of
+// the last windows is found, averaging the middle two of an even number
+// of windows. The blocks of the first window of the chain have the
+// minimum stake difficulty.
+func TestWindowsMedianDiff(t *testing.T) {
+	winSize := activeNet.StakeDiffWindowSize
+	minDiff := float64(activeNet.MinimumStakeDiff) / 1e8
+
+	h := newTestHarness(t, winSize-1)
+	cfg := newTestConfig(h)
+	b := startTestBuyer(t, h, cfg, nil, false)
+	defer b.stop()
+
+	// Connect the next two windows at stake difficulties of 10 and 30
+	// coins.
+	h.Dcrd.SetStakeDifficulty(10, 10)
+	for i := int64(0); i < winSize; i++ {
+		h.ConnectBlock()
+	}
+	h.Dcrd.SetStakeDifficulty(30, 30)
+	h.ConnectBlock()
+	height := int32(2 * winSize)
+
+	tests := []struct {
+		windows int
+		height  int32
+		want    float64
+	}{
+		{1, height, 30},
+		{2, height, 20},
+		{3, height, 10},
+		{10, height, 10},
+		{2, height - 1, (minDiff + 10) / 2},
+	}
+
+	for _, test := range tests {
+		cfg.AvgPriceWindows = test.windows
+		got, err := b.purchaser.windowsMedianDiff(test.height)
+		if err != nil {
+			t.Errorf("%v windows at height %v: unexpected error: %v",
+				test.windows, test.height, err)
+			continue
+		}
+		if got.ToCoin() != test.want {
+			t.Errorf("%v windows at height %v: got %v, want %v",
+				test.windows, test.height, got.ToCoin(), test.want)
+		}
+	}
+}
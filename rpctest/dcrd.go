@@ -0,0 +1,536 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpctest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrjson"
+	"github.com/decred/dcrd/txscript"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrutil"
+)
+
+// mempoolTicket is a ticket waiting in the fake daemon's mempool. The
+// transaction is only known for tickets added with AddMempoolTicket.
+type mempoolTicket struct {
+	hash    string
+	address string
+	tx      *wire.MsgTx
+}
+
+// FakeDcrd is a scripted fake of the dcrd RPC server. It keeps a chain of
+// synthetic block headers that grows with each call to ConnectBlock, and
+// a ticket mempool that is mined into the next connected block along with
+// the queued votes and revocations.
+type FakeDcrd struct {
+	*server
+	params *chaincfg.Params
+
+	mtx           sync.Mutex
+	height        int64
+	headers       map[int64]*wire.BlockHeader
+	heights       map[string]int64
+	poolValue     float64
+	vwap          float64
+	curStakeDiff  float64
+	nextStakeDiff float64
+	estimate      dcrjson.EstimateStakeDiffResult
+	ticketFee     float64
+	mempool       []*mempoolTicket
+	stakeQueue    []*wire.MsgTx
+	stakeTxs      map[int64][]*wire.MsgTx
+	missed        []string
+	mined         map[string]int64
+	outputs       map[string]*dcrjson.GetTxOutResult
+	published     []*wire.MsgTx
+	minedHook     func(tickets []string)
+}
+
+// newFakeDcrd creates a FakeDcrd with a chain of the given height whose
+// blocks all have the given ticket pool size.
+func newFakeDcrd(params *chaincfg.Params, height int64,
+	poolSize uint32) (*FakeDcrd, error) {
+	s, err := newServer()
+	if err != nil {
+		return nil, err
+	}
+	d := &FakeDcrd{
+		server:        s,
+		params:        params,
+		height:        height,
+		headers:       make(map[int64]*wire.BlockHeader),
+		heights:       make(map[string]int64),
+		stakeTxs:      make(map[int64][]*wire.MsgTx),
+		mined:         make(map[string]int64),
+		outputs:       make(map[string]*dcrjson.GetTxOutResult),
+		curStakeDiff:  float64(params.MinimumStakeDiff) / 1e8,
+		nextStakeDiff: float64(params.MinimumStakeDiff) / 1e8,
+		ticketFee:     0.01,
+	}
+	for h := int64(0); h <= height; h++ {
+		d.addHeader(h, poolSize, 0)
+	}
+	d.registerHandlers()
+
+	return d, nil
+}
+
+// blockHash returns the synthetic hash of the block at a height.
+func blockHash(height int64) chainhash.Hash {
+	return chainhash.HashH([]byte(fmt.Sprintf("block %d", height)))
+}
+
+// addHeader adds a header at a height using the current stake difficulty.
+// The mutex must be held or the fake not yet started.
+func (d *FakeDcrd) addHeader(height int64, poolSize uint32, freshStake uint8) {
+	sBits, _ := dcrutil.NewAmount(d.curStakeDiff)
+	d.headers[height] = &wire.BlockHeader{
+		PrevBlock:  blockHash(height - 1),
+		FreshStake: freshStake,
+		PoolSize:   poolSize,
+		SBits:      int64(sBits),
+		Height:     uint32(height),
+		Timestamp:  time.Unix(1454954400+height*300, 0),
+	}
+	d.heights[blockHash(height).String()] = height
+}
+
+// registerHandlers registers the handlers for the methods of the fake.
+func (d *FakeDcrd) registerHandlers() {
+	d.handle("notifyblocks", func([]json.RawMessage) (interface{}, error) {
+		return nil, nil
+	})
+	d.handle("version", versionHandler("dcrd", "dcrdjsonrpcapi"))
+	d.handle("getcurrentnet", func([]json.RawMessage) (interface{}, error) {
+		return uint32(d.params.Net), nil
+	})
+	d.handle("getbestblockhash", func([]json.RawMessage) (interface{}, error) {
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+		return blockHash(d.height).String(), nil
+	})
+	d.handle("getblockcount", func([]json.RawMessage) (interface{}, error) {
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+		return d.height, nil
+	})
+	d.handle("getblockhash", func(params []json.RawMessage) (interface{}, error) {
+		var height int64
+		if err := unmarshalParam(params, 0, &height); err != nil {
+			return nil, err
+		}
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+		if height < 0 || height > d.height {
+			return nil, fmt.Errorf("block number out of range")
+		}
+		return blockHash(height).String(), nil
+	})
+	d.handle("getblock", d.handleGetBlock)
+	d.handle("getticketpoolvalue", func([]json.RawMessage) (interface{}, error) {
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+		return d.poolValue, nil
+	})
+	d.handle("ticketvwap", func([]json.RawMessage) (interface{}, error) {
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+		return d.vwap, nil
+	})
+	d.handle("estimatestakediff", func([]json.RawMessage) (interface{}, error) {
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+		estimate := d.estimate
+		return &estimate, nil
+	})
+	d.handle("getstakedifficulty", func([]json.RawMessage) (interface{}, error) {
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+		return &dcrjson.GetStakeDifficultyResult{
+			CurrentStakeDifficulty: d.curStakeDiff,
+			NextStakeDifficulty:    d.nextStakeDiff,
+		}, nil
+	})
+	d.handle("ticketfeeinfo", d.handleTicketFeeInfo)
+	d.handle("getrawmempool", func([]json.RawMessage) (interface{}, error) {
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+		hashes := make([]string, len(d.mempool))
+		for i, ticket := range d.mempool {
+			hashes[i] = ticket.hash
+		}
+		return hashes, nil
+	})
+	d.handle("getrawtransaction", d.handleGetRawTransaction)
+	d.handle("missedtickets", func([]json.RawMessage) (interface{}, error) {
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+		return &dcrjson.MissedTicketsResult{
+			Tickets: append([]string{}, d.missed...),
+		}, nil
+	})
+	d.handle("gettxout", d.handleGetTxOut)
+	d.handle("sendrawtransaction", d.handleSendRawTransaction)
+}
+
+// handleGetBlock returns the serialized block for a hash. Blocks contain
+// a header and the known stake transactions mined in them.
+func (d *FakeDcrd) handleGetBlock(params []json.RawMessage) (interface{}, error) {
+	var hash string
+	if err := unmarshalParam(params, 0, &hash); err != nil {
+		return nil, err
+	}
+
+	d.mtx.Lock()
+	height, ok := d.heights[hash]
+	var block wire.MsgBlock
+	if ok {
+		block.Header = *d.headers[height]
+		block.STransactions = d.stakeTxs[height]
+	}
+	d.mtx.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("block not found")
+	}
+
+	var buf bytes.Buffer
+	if err := block.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// handleTicketFeeInfo returns the scripted ticket fee for every requested
+// block and window, along with the number of tickets mined in each window.
+func (d *FakeDcrd) handleTicketFeeInfo(params []json.RawMessage) (interface{}, error) {
+	var blocks, windows uint32
+	if err := unmarshalParam(params, 0, &blocks); err != nil {
+		return nil, err
+	}
+	if err := unmarshalParam(params, 1, &windows); err != nil {
+		return nil, err
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	result := &dcrjson.TicketFeeInfoResult{}
+	for i := int64(0); i < int64(blocks) && d.height-i >= 0; i++ {
+		header := d.headers[d.height-i]
+		result.FeeInfoBlocks = append(result.FeeInfoBlocks,
+			dcrjson.FeeInfoBlock{
+				Height: uint32(d.height - i),
+				Number: uint32(header.FreshStake),
+				Mean:   d.ticketFee,
+				Median: d.ticketFee,
+			})
+	}
+
+	winSize := d.params.StakeDiffWindowSize
+	start := (d.height / winSize) * winSize
+	for i := int64(0); i < int64(windows) && start >= 0; i++ {
+		number := uint32(0)
+		for h := start; h < start+winSize && h <= d.height; h++ {
+			number += uint32(d.headers[h].FreshStake)
+		}
+		result.FeeInfoWindows = append(result.FeeInfoWindows,
+			dcrjson.FeeInfoWindow{
+				StartHeight: uint32(start),
+				EndHeight:   uint32(start + winSize),
+				Number:      number,
+				Mean:        d.ticketFee,
+				Median:      d.ticketFee,
+			})
+		start -= winSize
+	}
+
+	return result, nil
+}
+
+// handleGetRawTransaction returns a verbose result for a ticket in the
+// mempool, with its first output paying to the ticket address, or the
+// serialized ticket if it was added with AddMempoolTicket and the result
+// is not verbose.
+func (d *FakeDcrd) handleGetRawTransaction(params []json.RawMessage) (interface{}, error) {
+	var hash string
+	if err := unmarshalParam(params, 0, &hash); err != nil {
+		return nil, err
+	}
+	var verbose int
+	if err := unmarshalParam(params, 1, &verbose); err != nil {
+		return nil, err
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, ticket := range d.mempool {
+		if ticket.hash != hash {
+			continue
+		}
+		if verbose == 0 {
+			if ticket.tx == nil {
+				break
+			}
+			var buf bytes.Buffer
+			if err := ticket.tx.Serialize(&buf); err != nil {
+				return nil, err
+			}
+			return hex.EncodeToString(buf.Bytes()), nil
+		}
+		return &dcrjson.TxRawResult{
+			Txid: hash,
+			Vout: []dcrjson.Vout{{
+				Value: d.nextStakeDiff,
+				ScriptPubKey: dcrjson.ScriptPubKeyResult{
+					Type:      "stakesubmission",
+					Addresses: []string{ticket.address},
+				},
+			}},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no information available about transaction")
+}
+
+// handleGetTxOut returns an unspent output added with addOutput, the first
+// output of a mined ticket, or of a ticket in the mempool if the mempool
+// is included, and null otherwise.
+func (d *FakeDcrd) handleGetTxOut(params []json.RawMessage) (interface{}, error) {
+	var hash string
+	if err := unmarshalParam(params, 0, &hash); err != nil {
+		return nil, err
+	}
+	var vout uint32
+	if err := unmarshalParam(params, 1, &vout); err != nil {
+		return nil, err
+	}
+	includeMempool := true
+	if err := unmarshalParam(params, 2, &includeMempool); err != nil {
+		return nil, err
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if output, ok := d.outputs[outPointKey(hash, vout)]; ok {
+		result := *output
+		result.BestBlock = blockHash(d.height).String()
+		return &result, nil
+	}
+	result := &dcrjson.GetTxOutResult{
+		BestBlock: blockHash(d.height).String(),
+		Value:     d.nextStakeDiff,
+	}
+	if height, ok := d.mined[hash]; ok {
+		result.Confirmations = d.height - height + 1
+		return result, nil
+	}
+	if includeMempool {
+		for _, ticket := range d.mempool {
+			if ticket.hash == hash {
+				return result, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// handleSendRawTransaction records a published transaction and spends the
+// outputs added with addOutput that it spends.
+func (d *FakeDcrd) handleSendRawTransaction(params []json.RawMessage) (interface{}, error) {
+	var txHex string
+	if err := unmarshalParam(params, 0, &txHex); err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, err
+	}
+	var mtx wire.MsgTx
+	if err := mtx.Deserialize(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+
+	d.mtx.Lock()
+	d.published = append(d.published, &mtx)
+	for _, txIn := range mtx.TxIn {
+		prevOut := &txIn.PreviousOutPoint
+		delete(d.outputs, outPointKey(prevOut.Hash.String(), prevOut.Index))
+	}
+	d.mtx.Unlock()
+
+	return chainhash.HashH(b).String(), nil
+}
+
+// SetStakeDifficulty sets the stake difficulty of the current block and
+// of the next block.
+func (d *FakeDcrd) SetStakeDifficulty(cur, next float64) {
+	d.mtx.Lock()
+	d.curStakeDiff = cur
+	d.nextStakeDiff = next
+	d.mtx.Unlock()
+}
+
+// SetStakeDiffEstimate sets the result of estimatestakediff.
+func (d *FakeDcrd) SetStakeDiffEstimate(min, max, expected float64) {
+	d.mtx.Lock()
+	d.estimate = dcrjson.EstimateStakeDiffResult{
+		Min:      min,
+		Max:      max,
+		Expected: expected,
+	}
+	d.mtx.Unlock()
+}
+
+// SetTicketPoolValue sets the total value of the ticket pool in coins.
+func (d *FakeDcrd) SetTicketPoolValue(value float64) {
+	d.mtx.Lock()
+	d.poolValue = value
+	d.mtx.Unlock()
+}
+
+// SetTicketVWAP sets the ticket VWAP in coins.
+func (d *FakeDcrd) SetTicketVWAP(vwap float64) {
+	d.mtx.Lock()
+	d.vwap = vwap
+	d.mtx.Unlock()
+}
+
+// SetTicketFee sets the mean and median ticket fee per KB of every block
+// and window.
+func (d *FakeDcrd) SetTicketFee(fee float64) {
+	d.mtx.Lock()
+	d.ticketFee = fee
+	d.mtx.Unlock()
+}
+
+// addMempoolTicket adds a ticket paying to address to the mempool.
+func (d *FakeDcrd) addMempoolTicket(hash, address string) {
+	d.mtx.Lock()
+	d.mempool = append(d.mempool, &mempoolTicket{hash: hash, address: address})
+	d.mtx.Unlock()
+}
+
+// AddMempoolTicket adds a ticket transaction to the mempool. It is
+// included in the block that mines it.
+func (d *FakeDcrd) AddMempoolTicket(tx *wire.MsgTx) {
+	ticket := &mempoolTicket{hash: tx.TxSha().String(), tx: tx}
+	out := tx.TxOut[0]
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(out.Version,
+		out.PkScript, d.params)
+	if err == nil && len(addrs) == 1 {
+		ticket.address = addrs[0].EncodeAddress()
+	}
+
+	d.mtx.Lock()
+	d.mempool = append(d.mempool, ticket)
+	d.mtx.Unlock()
+}
+
+// QueueStakeTx queues a vote or revocation to be included in the next
+// connected block.
+func (d *FakeDcrd) QueueStakeTx(tx *wire.MsgTx) {
+	d.mtx.Lock()
+	d.stakeQueue = append(d.stakeQueue, tx)
+	d.mtx.Unlock()
+}
+
+// SetMissedTickets sets the hashes of the tickets returned by
+// missedtickets.
+func (d *FakeDcrd) SetMissedTickets(hashes []string) {
+	d.mtx.Lock()
+	d.missed = hashes
+	d.mtx.Unlock()
+}
+
+// addOutput adds a confirmed unspent output of amount coins paying to
+// pkScript, which is returned by gettxout until it is spent.
+func (d *FakeDcrd) addOutput(txID string, vout uint32, amount float64,
+	pkScript []byte) {
+	d.mtx.Lock()
+	d.outputs[outPointKey(txID, vout)] = &dcrjson.GetTxOutResult{
+		Confirmations: 6,
+		Value:         amount,
+		ScriptPubKey: dcrjson.ScriptPubKeyResult{
+			Hex: hex.EncodeToString(pkScript),
+		},
+	}
+	d.mtx.Unlock()
+}
+
+// DropMempool removes the tickets in the mempool without mining them, as
+// if they were evicted.
+func (d *FakeDcrd) DropMempool() {
+	d.mtx.Lock()
+	d.mempool = nil
+	d.mtx.Unlock()
+}
+
+// Published returns the transactions published with sendrawtransaction.
+func (d *FakeDcrd) Published() []*wire.MsgTx {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return append([]*wire.MsgTx(nil), d.published...)
+}
+
+// Fail makes all following calls to method return err. A nil error
+// removes the failure.
+func (d *FakeDcrd) Fail(method string, err error) {
+	d.fail(method, err)
+}
+
+// ConnectBlock connects a new block that mines the tickets in the mempool
+// up to the maximum fresh stake per block and the queued votes and
+// revocations, and notifies connected clients.
+// The height of the new block is returned.
+func (d *FakeDcrd) ConnectBlock() int64 {
+	d.mtx.Lock()
+	numMined := len(d.mempool)
+	if numMined > int(d.params.MaxFreshStakePerBlock) {
+		numMined = int(d.params.MaxFreshStakePerBlock)
+	}
+	mined := make([]string, numMined)
+	var stakeTxs []*wire.MsgTx
+	for i := range mined {
+		mined[i] = d.mempool[i].hash
+		if d.mempool[i].tx != nil {
+			stakeTxs = append(stakeTxs, d.mempool[i].tx)
+		}
+	}
+	d.mempool = d.mempool[numMined:]
+	stakeTxs = append(stakeTxs, d.stakeQueue...)
+	d.stakeQueue = nil
+
+	poolSize := d.headers[d.height].PoolSize
+	d.height++
+	if len(stakeTxs) != 0 {
+		d.stakeTxs[d.height] = stakeTxs
+	}
+	for _, hash := range mined {
+		d.mined[hash] = d.height
+	}
+	d.addHeader(d.height, poolSize, uint8(numMined))
+	height := d.height
+	hook := d.minedHook
+	d.mtx.Unlock()
+
+	if hook != nil {
+		hook(mined)
+	}
+
+	hash := blockHash(height)
+	d.notify("blockconnected", hash.String(), int32(height),
+		time.Unix(1454954400+height*300, 0).Unix(), uint16(1))
+
+	return height
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpctest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg"
+)
+
+// Harness runs a fake dcrd and a fake dcrwallet attached to it on local
+// ports. Point the ticket buyer's dcrdserv and dcrwserv at DcrdAddr and
+// WalletAddr with client TLS disabled.
+type Harness struct {
+	Dcrd   *FakeDcrd
+	Wallet *FakeWallet
+}
+
+// New starts a harness for the given network with a chain of the given
+// height whose blocks have the target ticket pool size of the network.
+func New(params *chaincfg.Params, height int64) (*Harness, error) {
+	poolSize := uint32(params.TicketsPerBlock) * uint32(params.TicketPoolSize)
+	dcrd, err := newFakeDcrd(params, height, poolSize)
+	if err != nil {
+		return nil, err
+	}
+	wallet, err := newFakeWallet(dcrd)
+	if err != nil {
+		dcrd.stop()
+		return nil, err
+	}
+	dcrd.minedHook = wallet.ticketsMined
+
+	dcrd.start()
+	wallet.start()
+
+	return &Harness{
+		Dcrd:   dcrd,
+		Wallet: wallet,
+	}, nil
+}
+
+// DcrdAddr returns the host and port of the fake dcrd RPC server.
+func (h *Harness) DcrdAddr() string {
+	return h.Dcrd.addr()
+}
+
+// WalletAddr returns the host and port of the fake dcrwallet RPC server.
+func (h *Harness) WalletAddr() string {
+	return h.Wallet.addr()
+}
+
+// ConnectBlock connects a new block on the fake daemon and notifies the
+// connected clients. It returns the height of the new block.
+func (h *Harness) ConnectBlock() int64 {
+	return h.Dcrd.ConnectBlock()
+}
+
+// WaitForCalls waits until method has been called on the fake wallet at
+// least n times in total, or the timeout passes. This is used to wait for
+// the ticket buyer to finish handling a block.
+func (h *Harness) WaitForCalls(method string, n int,
+	timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for h.Wallet.CallCount(method) < n {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %v calls to %v "+
+				"(got %v)", n, method, h.Wallet.CallCount(method))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// Close stops both fake servers.
+func (h *Harness) Close() {
+	h.Wallet.stop()
+	h.Dcrd.stop()
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package rpctest provides scripted fake dcrd and dcrwallet websocket
+// JSON-RPC servers for exercising the ticket buyer end to end without a
+// network connection. The fakes implement the RPC methods that the ticket
+// buyer calls, allow the chain state, prices, wallet lock state and RPC
+// failures to be scripted, and record the ticket purchases made.
+package rpctest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/btcsuite/websocket"
+	"github.com/decred/dcrd/dcrjson"
+)
+
+// handler handles a single JSON-RPC method call and returns its result.
+type handler func(params []json.RawMessage) (interface{}, error)
+
+// request is a JSON-RPC request received from a client.
+type request struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	ID     interface{}       `json:"id"`
+}
+
+// response is a JSON-RPC response sent to a client.
+type response struct {
+	Result interface{}       `json:"result"`
+	Error  *dcrjson.RPCError `json:"error"`
+	ID     interface{}       `json:"id"`
+}
+
+// notification is a JSON-RPC notification sent to a client.
+type notification struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+	ID      interface{}   `json:"id"`
+}
+
+// server is a websocket JSON-RPC server that dispatches calls to scripted
+// handlers. Any method may be made to fail with a scripted error.
+type server struct {
+	listener net.Listener
+	upgrader websocket.Upgrader
+
+	mtx      sync.Mutex
+	handlers map[string]handler
+	failures map[string]error
+	calls    map[string]int
+	conns    map[*websocket.Conn]*sync.Mutex
+}
+
+// newServer creates a server listening on a random local port.
+func newServer() (*server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &server{
+		listener: listener,
+		handlers: make(map[string]handler),
+		failures: make(map[string]error),
+		calls:    make(map[string]int),
+		conns:    make(map[*websocket.Conn]*sync.Mutex),
+	}
+	s.handle("help", s.handleHelp)
+
+	return s, nil
+}
+
+// handleHelp returns a short usage string for a method if the server has a
+// handler for it, and an error otherwise.
+func (s *server) handleHelp(params []json.RawMessage) (interface{}, error) {
+	var method string
+	if err := unmarshalParam(params, 0, &method); err != nil {
+		return nil, err
+	}
+	if method == "" {
+		return "help (\"command\")", nil
+	}
+	s.mtx.Lock()
+	_, ok := s.handlers[method]
+	s.mtx.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown command %v", method)
+	}
+	return method, nil
+}
+
+// versionResult is a single entry of the result of the version method.
+type versionResult struct {
+	VersionString string `json:"versionstring"`
+	Major         uint32 `json:"major"`
+	Minor         uint32 `json:"minor"`
+	Patch         uint32 `json:"patch"`
+}
+
+// versionHandler returns a handler for the version method reporting
+// version 1.0.0 of both the named server and its JSON-RPC API.
+func versionHandler(server, api string) handler {
+	version := &versionResult{"1.0.0", 1, 0, 0}
+	return func([]json.RawMessage) (interface{}, error) {
+		return map[string]*versionResult{
+			server: version,
+			api:    version,
+		}, nil
+	}
+}
+
+// handle registers the handler for a method.
+func (s *server) handle(method string, h handler) {
+	s.mtx.Lock()
+	s.handlers[method] = h
+	s.mtx.Unlock()
+}
+
+// start begins serving websocket connections.
+func (s *server) start() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.serveWS)
+	go http.Serve(s.listener, mux)
+}
+
+// stop closes the listener and all client connections.
+func (s *server) stop() {
+	s.listener.Close()
+	s.mtx.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mtx.Unlock()
+}
+
+// addr returns the host and port the server is listening on.
+func (s *server) addr() string {
+	return s.listener.Addr().String()
+}
+
+// fail makes all following calls to method return err. A nil error
+// removes the failure.
+func (s *server) fail(method string, err error) {
+	s.mtx.Lock()
+	if err == nil {
+		delete(s.failures, method)
+	} else {
+		s.failures[method] = err
+	}
+	s.mtx.Unlock()
+}
+
+// callCount returns the number of times method has been called.
+func (s *server) callCount(method string) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.calls[method]
+}
+
+// serveWS upgrades a connection to a websocket and serves requests on it
+// until it is closed.
+func (s *server) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	writeMtx := new(sync.Mutex)
+	s.mtx.Lock()
+	s.conns[conn] = writeMtx
+	s.mtx.Unlock()
+
+	defer func() {
+		s.mtx.Lock()
+		delete(s.conns, conn)
+		s.mtx.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req request
+		if err := json.Unmarshal(msg, &req); err != nil {
+			continue
+		}
+
+		resp := &response{ID: req.ID}
+		result, err := s.dispatch(&req)
+		if err != nil {
+			resp.Error = &dcrjson.RPCError{
+				Code:    dcrjson.ErrRPCMisc,
+				Message: err.Error(),
+			}
+		} else {
+			resp.Result = result
+		}
+
+		writeMtx.Lock()
+		err = conn.WriteJSON(resp)
+		writeMtx.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// dispatch calls the handler for a request, or returns the scripted
+// failure for the method if there is one.
+func (s *server) dispatch(req *request) (interface{}, error) {
+	s.mtx.Lock()
+	s.calls[req.Method]++
+	h, ok := s.handlers[req.Method]
+	failure := s.failures[req.Method]
+	s.mtx.Unlock()
+
+	if failure != nil {
+		return nil, failure
+	}
+	if !ok {
+		return nil, fmt.Errorf("method %v not found", req.Method)
+	}
+	return h(req.Params)
+}
+
+// notify sends a notification to all connected clients.
+func (s *server) notify(method string, params ...interface{}) {
+	ntfn := &notification{
+		JSONRPC: "1.0",
+		Method:  method,
+		Params:  params,
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for conn, writeMtx := range s.conns {
+		writeMtx.Lock()
+		conn.WriteJSON(ntfn)
+		writeMtx.Unlock()
+	}
+}
+
+// unmarshalParam decodes the parameter at index i into v if it is present
+// and not null.
+func unmarshalParam(params []json.RawMessage, i int, v interface{}) error {
+	if i >= len(params) || string(params[i]) == "null" {
+		return nil
+	}
+	return json.Unmarshal(params[i], v)
+}
@@ -0,0 +1,516 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package rpctest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/decred/dcrd/chaincfg/chainec"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrjson"
+	"github.com/decred/dcrd/txscript"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrutil"
+)
+
+// PurchaseTicketCall records the arguments of a purchaseticket call made
+// to the fake wallet and the tickets that it returned.
+type PurchaseTicketCall struct {
+	Account       string
+	SpendLimit    float64
+	MinConf       int
+	TicketAddress string
+	NumTickets    int
+	PoolAddress   string
+	PoolFees      float64
+	Expiry        int
+	Tickets       []string
+}
+
+// FakeWallet is a scripted fake of the dcrwallet RPC server. Tickets that
+// it purchases are added to the mempool of the fake daemon it is attached
+// to and are deducted from its spendable balance. Every address it returns
+// has a private key, which is used to sign inputs spending the outputs
+// added with AddUnspent.
+type FakeWallet struct {
+	*server
+	dcrd *FakeDcrd
+
+	mtx             sync.Mutex
+	unlocked        bool
+	unlocks         int
+	daemonConnected bool
+	balance         float64
+	lockedBalance   float64
+	ticketFee       float64
+	txFee           float64
+	stakeInfo       dcrjson.GetStakeInfoResult
+	unspent         []dcrjson.ListUnspentResult
+	locked          map[string]struct{}
+	keys            map[string]chainec.PrivateKey
+	sends           []map[string]float64
+	nextAddr        uint32
+	nextOutput      uint32
+	nextTicket      uint32
+	purchases       []*PurchaseTicketCall
+}
+
+// newFakeWallet creates a FakeWallet attached to a fake daemon. The wallet
+// starts unlocked and connected to the daemon.
+func newFakeWallet(dcrd *FakeDcrd) (*FakeWallet, error) {
+	s, err := newServer()
+	if err != nil {
+		return nil, err
+	}
+	w := &FakeWallet{
+		server:          s,
+		dcrd:            dcrd,
+		unlocked:        true,
+		daemonConnected: true,
+		locked:          make(map[string]struct{}),
+		keys:            make(map[string]chainec.PrivateKey),
+	}
+	w.registerHandlers()
+
+	return w, nil
+}
+
+// newAddress returns a new unique pay-to-pubkey-hash address on the
+// network of the fake, remembering its private key.
+func (w *FakeWallet) newAddress() (dcrutil.Address, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.nextAddr++
+	seed := chainhash.HashB([]byte(fmt.Sprintf("key %d", w.nextAddr)))
+	privKey, pubKey := chainec.Secp256k1.PrivKeyFromBytes(seed)
+	addr, err := dcrutil.NewAddressPubKeyHash(
+		dcrutil.Hash160(pubKey.SerializeCompressed()), w.dcrd.params,
+		chainec.ECTypeSecp256k1)
+	if err != nil {
+		return nil, err
+	}
+	w.keys[addr.EncodeAddress()] = privKey
+	return addr, nil
+}
+
+// outPointKey returns the key of an output in the maps of the fakes.
+func outPointKey(txID string, vout uint32) string {
+	return fmt.Sprintf("%s:%d", txID, vout)
+}
+
+// registerHandlers registers the handlers for the methods of the fake.
+func (w *FakeWallet) registerHandlers() {
+	w.handle("version", versionHandler("dcrwallet", "dcrwalletjsonrpcapi"))
+	w.handle("getcurrentnet", func([]json.RawMessage) (interface{}, error) {
+		return uint32(w.dcrd.params.Net), nil
+	})
+	w.handle("listaccounts", func([]json.RawMessage) (interface{}, error) {
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		return map[string]float64{"default": w.balance}, nil
+	})
+	w.handle("walletinfo", func([]json.RawMessage) (interface{}, error) {
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		return &dcrjson.WalletInfoResult{
+			DaemonConnected: w.daemonConnected,
+			Unlocked:        w.unlocked,
+			TxFee:           w.txFee,
+			TicketFee:       w.ticketFee,
+		}, nil
+	})
+	w.handle("settxfee", func(params []json.RawMessage) (interface{}, error) {
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		return true, unmarshalParam(params, 0, &w.txFee)
+	})
+	w.handle("setticketfee", func(params []json.RawMessage) (interface{}, error) {
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		return true, unmarshalParam(params, 0, &w.ticketFee)
+	})
+	w.handle("getstakedifficulty", func([]json.RawMessage) (interface{}, error) {
+		w.dcrd.mtx.Lock()
+		defer w.dcrd.mtx.Unlock()
+		return &dcrjson.GetStakeDifficultyResult{
+			CurrentStakeDifficulty: w.dcrd.curStakeDiff,
+			NextStakeDifficulty:    w.dcrd.nextStakeDiff,
+		}, nil
+	})
+	w.handle("getbalance", func(params []json.RawMessage) (interface{}, error) {
+		var balanceType string
+		if err := unmarshalParam(params, 2, &balanceType); err != nil {
+			return nil, err
+		}
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		if balanceType == "locked" {
+			return w.lockedBalance, nil
+		}
+		return w.balance, nil
+	})
+	w.handle("getstakeinfo", func([]json.RawMessage) (interface{}, error) {
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		stakeInfo := w.stakeInfo
+		return &stakeInfo, nil
+	})
+	newAddressHandler := func([]json.RawMessage) (interface{}, error) {
+		addr, err := w.newAddress()
+		if err != nil {
+			return nil, err
+		}
+		return addr.EncodeAddress(), nil
+	}
+	w.handle("getrawchangeaddress", newAddressHandler)
+	w.handle("getnewaddress", newAddressHandler)
+	w.handle("purchaseticket", w.handlePurchaseTicket)
+	w.handle("listunspent", func([]json.RawMessage) (interface{}, error) {
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		unspent := make([]dcrjson.ListUnspentResult, 0, len(w.unspent))
+		for _, u := range w.unspent {
+			if _, ok := w.locked[outPointKey(u.TxID, u.Vout)]; ok {
+				continue
+			}
+			unspent = append(unspent, u)
+		}
+		return unspent, nil
+	})
+	w.handle("lockunspent", func(params []json.RawMessage) (interface{}, error) {
+		var unlock bool
+		if err := unmarshalParam(params, 0, &unlock); err != nil {
+			return nil, err
+		}
+		var outputs []dcrjson.TransactionInput
+		if err := unmarshalParam(params, 1, &outputs); err != nil {
+			return nil, err
+		}
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		for _, output := range outputs {
+			key := outPointKey(output.Txid, output.Vout)
+			if unlock {
+				delete(w.locked, key)
+			} else {
+				w.locked[key] = struct{}{}
+			}
+		}
+		return true, nil
+	})
+	w.handle("signrawtransaction", w.handleSignRawTransaction)
+	w.handle("sendmany", func(params []json.RawMessage) (interface{}, error) {
+		var amounts map[string]float64
+		if err := unmarshalParam(params, 1, &amounts); err != nil {
+			return nil, err
+		}
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		if !w.unlocked {
+			return nil, fmt.Errorf("wallet is locked")
+		}
+		total := 0.0
+		for _, amt := range amounts {
+			total += amt
+		}
+		if total > w.balance {
+			return nil, fmt.Errorf("insufficient funds")
+		}
+		w.balance -= total
+		w.sends = append(w.sends, amounts)
+		hash := chainhash.HashH([]byte(fmt.Sprintf("send %d",
+			len(w.sends))))
+		return hash.String(), nil
+	})
+	w.handle("walletpassphrase", func([]json.RawMessage) (interface{}, error) {
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		w.unlocked = true
+		w.unlocks++
+		return nil, nil
+	})
+	w.handle("walletlock", func([]json.RawMessage) (interface{}, error) {
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		w.unlocked = false
+		return nil, nil
+	})
+}
+
+// handleSignRawTransaction signs the inputs of a transaction that spend
+// outputs added with AddUnspent. Other inputs are left as they are, and
+// the transaction is always reported complete while the wallet is
+// unlocked.
+func (w *FakeWallet) handleSignRawTransaction(params []json.RawMessage) (interface{}, error) {
+	var txHex string
+	if err := unmarshalParam(params, 0, &txHex); err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, err
+	}
+	var mtx wire.MsgTx
+	if err := mtx.Deserialize(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if !w.unlocked {
+		return nil, fmt.Errorf("wallet is locked")
+	}
+	for i, txIn := range mtx.TxIn {
+		prevOut := &txIn.PreviousOutPoint
+		for _, u := range w.unspent {
+			if u.TxID != prevOut.Hash.String() || u.Vout != prevOut.Index {
+				continue
+			}
+			privKey, ok := w.keys[u.Address]
+			if !ok {
+				continue
+			}
+			pkScript, err := hex.DecodeString(u.ScriptPubKey)
+			if err != nil {
+				return nil, err
+			}
+			sigScript, err := txscript.SignatureScript(&mtx, i, pkScript,
+				txscript.SigHashAll, privKey, true)
+			if err != nil {
+				return nil, err
+			}
+			txIn.SignatureScript = sigScript
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(mtx.SerializeSize())
+	if err := mtx.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return &dcrjson.SignRawTransactionResult{
+		Hex:      hex.EncodeToString(buf.Bytes()),
+		Complete: true,
+	}, nil
+}
+
+// handlePurchaseTicket purchases tickets at the next stake difficulty of
+// the fake daemon and records the call.
+func (w *FakeWallet) handlePurchaseTicket(params []json.RawMessage) (interface{}, error) {
+	call := &PurchaseTicketCall{NumTickets: 1}
+	if err := unmarshalParam(params, 0, &call.Account); err != nil {
+		return nil, err
+	}
+	if err := unmarshalParam(params, 1, &call.SpendLimit); err != nil {
+		return nil, err
+	}
+	if err := unmarshalParam(params, 2, &call.MinConf); err != nil {
+		return nil, err
+	}
+	if err := unmarshalParam(params, 3, &call.TicketAddress); err != nil {
+		return nil, err
+	}
+	if err := unmarshalParam(params, 4, &call.NumTickets); err != nil {
+		return nil, err
+	}
+	if err := unmarshalParam(params, 5, &call.PoolAddress); err != nil {
+		return nil, err
+	}
+	if err := unmarshalParam(params, 6, &call.PoolFees); err != nil {
+		return nil, err
+	}
+	if err := unmarshalParam(params, 7, &call.Expiry); err != nil {
+		return nil, err
+	}
+
+	w.dcrd.mtx.Lock()
+	price := w.dcrd.nextStakeDiff
+	w.dcrd.mtx.Unlock()
+
+	w.mtx.Lock()
+	if !w.unlocked {
+		w.mtx.Unlock()
+		return nil, fmt.Errorf("wallet is locked")
+	}
+	if price > call.SpendLimit {
+		w.mtx.Unlock()
+		return nil, fmt.Errorf("ticket price %v above spend limit %v",
+			price, call.SpendLimit)
+	}
+	cost := price * float64(call.NumTickets)
+	if cost > w.balance {
+		w.mtx.Unlock()
+		return nil, fmt.Errorf("insufficient funds")
+	}
+	w.balance -= cost
+	w.lockedBalance += cost
+	w.stakeInfo.OwnMempoolTix += uint32(call.NumTickets)
+	for i := 0; i < call.NumTickets; i++ {
+		w.nextTicket++
+		hash := chainhash.HashH([]byte(fmt.Sprintf("ticket %d",
+			w.nextTicket)))
+		call.Tickets = append(call.Tickets, hash.String())
+	}
+	w.purchases = append(w.purchases, call)
+	w.mtx.Unlock()
+
+	for _, ticket := range call.Tickets {
+		w.dcrd.addMempoolTicket(ticket, call.TicketAddress)
+	}
+
+	return call.Tickets, nil
+}
+
+// ticketsMined moves the wallet's mined tickets from the mempool to
+// immature in its stake info.
+func (w *FakeWallet) ticketsMined(tickets []string) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	own := make(map[string]struct{})
+	for _, call := range w.purchases {
+		for _, ticket := range call.Tickets {
+			own[ticket] = struct{}{}
+		}
+	}
+	for _, ticket := range tickets {
+		if _, ok := own[ticket]; ok {
+			w.stakeInfo.OwnMempoolTix--
+			w.stakeInfo.Immature++
+		}
+	}
+}
+
+// Unlocks returns the number of times the wallet has been unlocked with
+// walletpassphrase.
+func (w *FakeWallet) Unlocks() int {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.unlocks
+}
+
+// SetUnspent sets the unspent outputs returned by listunspent. Signing a
+// transaction spending them always succeeds while the wallet is unlocked,
+// but leaves their inputs unsigned.
+func (w *FakeWallet) SetUnspent(unspent []dcrjson.ListUnspentResult) {
+	w.mtx.Lock()
+	w.unspent = unspent
+	w.mtx.Unlock()
+}
+
+// AddUnspent adds a confirmed output of amount coins paying to a new
+// address of the default account to the unspent outputs returned by
+// listunspent and to the outputs known to the fake daemon, and returns it.
+// Inputs spending it are signed by signrawtransaction.
+func (w *FakeWallet) AddUnspent(amount float64) (dcrjson.ListUnspentResult, error) {
+	addr, err := w.newAddress()
+	if err != nil {
+		return dcrjson.ListUnspentResult{}, err
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return dcrjson.ListUnspentResult{}, err
+	}
+
+	w.mtx.Lock()
+	w.nextOutput++
+	hash := chainhash.HashH([]byte(fmt.Sprintf("output %d", w.nextOutput)))
+	u := dcrjson.ListUnspentResult{
+		TxID:          hash.String(),
+		Address:       addr.EncodeAddress(),
+		Account:       "default",
+		ScriptPubKey:  hex.EncodeToString(pkScript),
+		Amount:        amount,
+		Confirmations: 6,
+		Spendable:     true,
+	}
+	w.unspent = append(w.unspent, u)
+	w.mtx.Unlock()
+
+	w.dcrd.addOutput(u.TxID, u.Vout, amount, pkScript)
+
+	return u, nil
+}
+
+// Locked returns whether an output is locked with lockunspent.
+func (w *FakeWallet) Locked(txID string, vout uint32) bool {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	_, ok := w.locked[outPointKey(txID, vout)]
+	return ok
+}
+
+// Sends returns the amounts sent to each address by every sendmany call
+// made to the fake.
+func (w *FakeWallet) Sends() []map[string]float64 {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	sends := make([]map[string]float64, len(w.sends))
+	copy(sends, w.sends)
+	return sends
+}
+
+// SetLocked sets whether or not the wallet is locked.
+func (w *FakeWallet) SetLocked(locked bool) {
+	w.mtx.Lock()
+	w.unlocked = !locked
+	w.mtx.Unlock()
+}
+
+// SetDaemonConnected sets whether or not the wallet reports that it is
+// connected to its daemon.
+func (w *FakeWallet) SetDaemonConnected(connected bool) {
+	w.mtx.Lock()
+	w.daemonConnected = connected
+	w.mtx.Unlock()
+}
+
+// SetBalance sets the spendable balance of the wallet in coins.
+func (w *FakeWallet) SetBalance(balance float64) {
+	w.mtx.Lock()
+	w.balance = balance
+	w.mtx.Unlock()
+}
+
+// SetStakeInfo sets the result of getstakeinfo.
+func (w *FakeWallet) SetStakeInfo(stakeInfo dcrjson.GetStakeInfoResult) {
+	w.mtx.Lock()
+	w.stakeInfo = stakeInfo
+	w.mtx.Unlock()
+}
+
+// Purchases returns the purchaseticket calls made so far.
+func (w *FakeWallet) Purchases() []PurchaseTicketCall {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	calls := make([]PurchaseTicketCall, len(w.purchases))
+	for i := range w.purchases {
+		calls[i] = *w.purchases[i]
+	}
+	return calls
+}
+
+// TicketFee returns the ticket fee per KB last set in the wallet.
+func (w *FakeWallet) TicketFee() float64 {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.ticketFee
+}
+
+// Fail makes all following calls to method return err. A nil error
+// removes the failure.
+func (w *FakeWallet) Fail(method string, err error) {
+	w.fail(method, err)
+}
+
+// CallCount returns the number of times method has been called.
+func (w *FakeWallet) CallCount(method string) int {
+	return w.callCount(method)
+}
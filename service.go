@@ -0,0 +1,73 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// writePIDFile writes the process ID to a file, replacing a file left
+// behind by an unclean shutdown.
+func writePIDFile(path string) error {
+	pid := strconv.Itoa(os.Getpid()) + "\n"
+	return ioutil.WriteFile(path, []byte(pid), 0644)
+}
+
+// removePIDFile removes the PID file written at startup.
+func removePIDFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Failed to remove PID file %v: %v", path, err)
+	}
+}
+
+// sdNotify sends a state notification to systemd when the ticket buyer is
+// run as a service of Type=notify. It does nothing when the NOTIFY_SOCKET
+// environment variable is not set.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// Sockets in the abstract namespace, given with a leading @, are
+	// handled by the net package.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{
+		Name: socket,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		log.Debugf("Failed to connect to the systemd notify socket: %v",
+			err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Debugf("Failed to notify systemd: %v", err)
+	}
+}
+
+// sdWatchdogInterval returns how often the systemd watchdog must be
+// notified, which is half of the watchdog timeout set for the service, or
+// zero if the watchdog is not enabled for this process.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" &&
+		pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// sdStatus sends a human-readable status line to systemd.
+func sdStatus(format string, a ...interface{}) {
+	sdNotify("STATUS=" + fmt.Sprintf(format, a...))
+}
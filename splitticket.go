@@ -0,0 +1,1018 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/blockchain/stake"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/txscript"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrutil"
+)
+
+const (
+	// splitFeeLimits are the fee limits encoded in each commitment output
+	// of a split ticket. This is the same default that the wallet uses.
+	splitFeeLimits = uint16(0x5800)
+
+	// splitTicketBaseSize is the estimated size in bytes of a split ticket
+	// without any participants, used to calculate its fee.
+	splitTicketBaseSize = 100
+
+	// splitTicketParticipantSize is the estimated size in bytes that each
+	// participant adds to a split ticket, consisting of a signed P2PKH
+	// input, a commitment output and a change output.
+	splitTicketParticipantSize = 270
+
+	// splitSessionTimeoutBlocks is the number of blocks a split ticket
+	// session may remain unpublished after it has been built before it
+	// is abandoned.
+	splitSessionTimeoutBlocks = 6
+
+	// splitClientTimeout is how long a participant waits for a response
+	// from the coordinator.
+	splitClientTimeout = 30 * time.Second
+
+	// splitSecretSize is the size in bytes of the secret a participant
+	// must present to sign its input.
+	splitSecretSize = 16
+)
+
+var (
+	// splitNoneStr is the string indicating that split tickets are
+	// disabled.
+	splitNoneStr = "none"
+
+	// splitCoordinatorStr is the string indicating that the ticket buyer
+	// should coordinate split tickets for participants.
+	splitCoordinatorStr = "coordinator"
+
+	// splitParticipantStr is the string indicating that the ticket buyer
+	// should contribute to split tickets built by a coordinator.
+	splitParticipantStr = "participant"
+)
+
+// splitSessionState is the state of a split ticket session.
+type splitSessionState string
+
+// These constants define the states of a split ticket session.
+const (
+	splitStateWaiting   splitSessionState = "waiting"
+	splitStateSigning   splitSessionState = "signing"
+	splitStatePublished splitSessionState = "published"
+	splitStateFailed    splitSessionState = "failed"
+)
+
+// splitInput is a transaction output used by a participant to fund their
+// contribution to a split ticket.
+type splitInput struct {
+	TxID   string `json:"txid"`
+	Vout   uint32 `json:"vout"`
+	Tree   int8   `json:"tree"`
+	Amount int64  `json:"amount"`
+}
+
+// splitJoinRequest is sent by a participant to join a split ticket session.
+type splitJoinRequest struct {
+	Contribution  int64      `json:"contribution"`
+	Input         splitInput `json:"input"`
+	CommitAddress string     `json:"commitaddress"`
+	ChangeAddress string     `json:"changeaddress"`
+}
+
+// splitJoinResponse is returned to a participant that joined a split
+// ticket session. The secret must be sent with the participant's
+// signature, so that no one else can sign its input.
+type splitJoinResponse struct {
+	Session int    `json:"session"`
+	Index   int    `json:"index"`
+	Secret  string `json:"secret"`
+	Error   string `json:"error,omitempty"`
+}
+
+// splitStatusResponse describes the state of a split ticket session. The
+// unsigned transaction is included once the session is being signed.
+type splitStatusResponse struct {
+	State  splitSessionState `json:"state"`
+	Tx     string            `json:"tx,omitempty"`
+	Ticket string            `json:"ticket,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// splitSignRequest is sent by a participant with the split ticket
+// transaction containing the signature for their input.
+type splitSignRequest struct {
+	Session int    `json:"session"`
+	Index   int    `json:"index"`
+	Secret  string `json:"secret"`
+	Tx      string `json:"tx"`
+}
+
+// splitSession is a split ticket being assembled by the coordinator.
+type splitSession struct {
+	id           int
+	state        splitSessionState
+	participants []*splitJoinRequest
+	secrets      []string
+	pkScripts    [][]byte
+	tx           *wire.MsgTx
+	signed       []bool
+	builtHeight  int32
+	ticketPrice  dcrutil.Amount
+	ticket       *chainhash.Hash
+	err          string
+}
+
+// serializeTx returns the hex encoded serialization of a transaction.
+func serializeTx(mtx *wire.MsgTx) (string, error) {
+	var buf bytes.Buffer
+	buf.Grow(mtx.SerializeSize())
+	if err := mtx.Serialize(&buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// deserializeTx decodes a hex encoded transaction.
+func deserializeTx(txHex string) (*wire.MsgTx, error) {
+	b, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, err
+	}
+	var mtx wire.MsgTx
+	if err := mtx.Deserialize(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	return &mtx, nil
+}
+
+// splitCommitments divides the ticket price plus fee between the
+// participants proportionally to their contributions. Any remainder from
+// rounding is assigned to the last participant.
+func splitCommitments(contributions []dcrutil.Amount,
+	total dcrutil.Amount) []dcrutil.Amount {
+	sum := dcrutil.Amount(0)
+	for _, c := range contributions {
+		sum += c
+	}
+
+	commitments := make([]dcrutil.Amount, len(contributions))
+	assigned := dcrutil.Amount(0)
+	for i, c := range contributions {
+		commitments[i] = dcrutil.Amount(float64(total) *
+			(float64(c) / float64(sum)))
+		assigned += commitments[i]
+	}
+	commitments[len(commitments)-1] += total - assigned
+
+	return commitments
+}
+
+// buildSplitTicket builds an unsigned split ticket transaction. Output 0
+// is the stake submission paying the ticket price to the voting address,
+// followed by a commitment and change output for each participant in the
+// same order as the inputs.
+func buildSplitTicket(participants []*splitJoinRequest,
+	ticketAddress dcrutil.Address, ticketPrice, fee dcrutil.Amount,
+	expiry uint32) (*wire.MsgTx, error) {
+	contributions := make([]dcrutil.Amount, len(participants))
+	for i, p := range participants {
+		contributions[i] = dcrutil.Amount(p.Contribution)
+	}
+	commitments := splitCommitments(contributions, ticketPrice+fee)
+
+	mtx := wire.NewMsgTx()
+	mtx.Expiry = expiry
+	for _, p := range participants {
+		hash, err := chainhash.NewHashFromStr(p.Input.TxID)
+		if err != nil {
+			return nil, err
+		}
+		txIn := wire.NewTxIn(wire.NewOutPoint(hash, p.Input.Vout,
+			p.Input.Tree), nil)
+		txIn.ValueIn = p.Input.Amount
+		mtx.AddTxIn(txIn)
+	}
+
+	pkScript, err := txscript.PayToSStx(ticketAddress)
+	if err != nil {
+		return nil, err
+	}
+	mtx.AddTxOut(wire.NewTxOut(int64(ticketPrice), pkScript))
+
+	for i, p := range participants {
+		commitAddr, err := dcrutil.DecodeAddress(p.CommitAddress,
+			activeNet.Params)
+		if err != nil {
+			return nil, err
+		}
+		changeAddr, err := dcrutil.DecodeAddress(p.ChangeAddress,
+			activeNet.Params)
+		if err != nil {
+			return nil, err
+		}
+		if commitments[i] > dcrutil.Amount(p.Input.Amount) {
+			return nil, fmt.Errorf("input of participant %v is too small "+
+				"for its commitment of %v", i, commitments[i])
+		}
+
+		commitScript, err := txscript.GenerateSStxAddrPush(commitAddr,
+			commitments[i], splitFeeLimits)
+		if err != nil {
+			return nil, err
+		}
+		mtx.AddTxOut(wire.NewTxOut(0, commitScript))
+
+		changeScript, err := txscript.PayToSStxChange(changeAddr)
+		if err != nil {
+			return nil, err
+		}
+		mtx.AddTxOut(wire.NewTxOut(p.Input.Amount-int64(commitments[i]),
+			changeScript))
+	}
+
+	return mtx, nil
+}
+
+// verifySplitTicket checks that a split ticket built by the coordinator
+// spends the participant's input at the given index, commits no more than
+// its contribution to its commitment address and returns the rest of the
+// input to its change address.
+func verifySplitTicket(mtx *wire.MsgTx, index int, req *splitJoinRequest,
+	maxPrice dcrutil.Amount) error {
+	if index >= len(mtx.TxIn) || len(mtx.TxOut) != 1+2*len(mtx.TxIn) {
+		return fmt.Errorf("malformed split ticket")
+	}
+	if dcrutil.Amount(mtx.TxOut[0].Value) > maxPrice {
+		return fmt.Errorf("ticket price %v is above the maximum price %v",
+			dcrutil.Amount(mtx.TxOut[0].Value), maxPrice)
+	}
+
+	prevOut := mtx.TxIn[index].PreviousOutPoint
+	if prevOut.Hash.String() != req.Input.TxID ||
+		prevOut.Index != req.Input.Vout {
+		return fmt.Errorf("split ticket input %v does not spend our output",
+			index)
+	}
+
+	commitScript := mtx.TxOut[1+2*index].PkScript
+	commitAddr, err := stake.AddrFromSStxPkScrCommitment(commitScript,
+		activeNet.Params)
+	if err != nil {
+		return err
+	}
+	if commitAddr.EncodeAddress() != req.CommitAddress {
+		return fmt.Errorf("split ticket commits to address %v instead of %v",
+			commitAddr, req.CommitAddress)
+	}
+	commitAmt, err := stake.AmountFromSStxPkScrCommitment(commitScript)
+	if err != nil {
+		return err
+	}
+	if commitAmt > dcrutil.Amount(req.Contribution) {
+		return fmt.Errorf("split ticket commitment %v is above our "+
+			"contribution %v", commitAmt, dcrutil.Amount(req.Contribution))
+	}
+
+	change := mtx.TxOut[2+2*index]
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(change.Version,
+		change.PkScript, activeNet.Params)
+	if err != nil {
+		return err
+	}
+	if len(addrs) != 1 || addrs[0].EncodeAddress() != req.ChangeAddress {
+		return fmt.Errorf("split ticket change does not pay to %v",
+			req.ChangeAddress)
+	}
+	if change.Value+int64(commitAmt) != req.Input.Amount {
+		return fmt.Errorf("split ticket change %v does not return the rest "+
+			"of our input", dcrutil.Amount(change.Value))
+	}
+
+	return nil
+}
+
+// splitCoordinator assembles split tickets from the contributions of
+// participants over a local HTTP channel. Participants join the open
+// session until their contributions cover the ticket price and fee, at
+// which point the ticket is built and each participant signs its own
+// input. Once every input is signed the ticket is published through
+// the daemon.
+type splitCoordinator struct {
+	purchaser *ticketPurchaser
+	listen    string
+
+	mtx           sync.Mutex
+	nextID        int
+	open          *splitSession
+	sessions      map[int]*splitSession
+	height        int32           // Height of the last block connected
+	ticketPrice   dcrutil.Amount  // Stake difficulty of the next block
+	feeRate       dcrutil.Amount  // Ticket fee per KB at the last block
+	expiry        int32           // Expiry of tickets built at the last block
+	ticketAddress dcrutil.Address // Voting address of the next ticket built
+}
+
+// newSplitCoordinator creates a new splitCoordinator.
+func newSplitCoordinator(purchaser *ticketPurchaser,
+	listen string) *splitCoordinator {
+	return &splitCoordinator{
+		purchaser: purchaser,
+		listen:    listen,
+		sessions:  make(map[int]*splitSession),
+	}
+}
+
+// handler returns the handler serving the split ticket protocol.
+func (c *splitCoordinator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/split/join", c.handleJoin)
+	mux.HandleFunc("/split/status", c.handleStatus)
+	mux.HandleFunc("/split/sign", c.handleSign)
+	return mux
+}
+
+// start begins serving the split ticket protocol.
+func (c *splitCoordinator) start() {
+	mux := c.handler()
+	go func() {
+		log.Infof("Split ticket coordinator listening on %v", c.listen)
+		err := http.ListenAndServe(c.listen, mux)
+		if err != nil {
+			log.Errorf("Split ticket coordinator failed: %v", err)
+		}
+	}()
+}
+
+// writeJSON writes a JSON encoded response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Failed to write response: %v", err)
+	}
+}
+
+// checkInput checks that the input of a participant is an unspent output
+// holding the amount the participant claims it does, and returns the
+// script it pays to so that the participant's signature can be verified.
+func (c *splitCoordinator) checkInput(input *splitInput) ([]byte, error) {
+	hash, err := chainhash.NewHashFromStr(input.TxID)
+	if err != nil {
+		return nil, err
+	}
+	txOut, err := c.purchaser.dcrdChainSvr.GetTxOut(hash, input.Vout, true)
+	if err != nil {
+		return nil, err
+	}
+	if txOut == nil {
+		return nil, fmt.Errorf("input %v:%v is spent or does not exist",
+			input.TxID, input.Vout)
+	}
+	amt, err := dcrutil.NewAmount(txOut.Value)
+	if err != nil {
+		return nil, err
+	}
+	if int64(amt) != input.Amount {
+		return nil, fmt.Errorf("input %v:%v holds %v, not %v", input.TxID,
+			input.Vout, amt, dcrutil.Amount(input.Amount))
+	}
+	return hex.DecodeString(txOut.ScriptPubKey.Hex)
+}
+
+// newSplitSecret returns a new random secret for a participant.
+func newSplitSecret() (string, error) {
+	b := make([]byte, splitSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// verifyInputSignature runs the script engine on the input of a
+// transaction at the given index against the script of the output it
+// spends.
+func verifyInputSignature(mtx *wire.MsgTx, index int, pkScript []byte) error {
+	vm, err := txscript.NewEngine(pkScript, mtx, index,
+		txscript.StandardVerifyFlags, txscript.DefaultScriptVersion, nil)
+	if err != nil {
+		return err
+	}
+	return vm.Execute()
+}
+
+// hasInput returns whether an input already funds a session that is
+// waiting for participants or being signed. The mutex must be held.
+func (c *splitCoordinator) hasInput(input *splitInput) bool {
+	for _, session := range c.sessions {
+		if session.state != splitStateWaiting &&
+			session.state != splitStateSigning {
+			continue
+		}
+		for _, p := range session.participants {
+			if p.Input.TxID == input.TxID && p.Input.Vout == input.Vout &&
+				p.Input.Tree == input.Tree {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleJoin adds a participant to the open session, building the split
+// ticket if the contributions now cover the ticket price and fee. The
+// participant's input must be unspent, hold the amount it claims to and
+// not already fund another participant. The participant is returned the
+// secret it must sign its input with.
+func (c *splitCoordinator) handleJoin(w http.ResponseWriter, r *http.Request) {
+	var req splitJoinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, &splitJoinResponse{Error: err.Error()})
+		return
+	}
+	if req.Contribution <= 0 || req.Contribution > req.Input.Amount {
+		writeJSON(w, &splitJoinResponse{Error: "invalid contribution"})
+		return
+	}
+	pkScript, err := c.checkInput(&req.Input)
+	if err != nil {
+		writeJSON(w, &splitJoinResponse{Error: err.Error()})
+		return
+	}
+	secret, err := newSplitSecret()
+	if err != nil {
+		writeJSON(w, &splitJoinResponse{Error: err.Error()})
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.hasInput(&req.Input) {
+		writeJSON(w, &splitJoinResponse{Error: "input already joined"})
+		return
+	}
+	if c.open == nil {
+		c.open = &splitSession{id: c.nextID, state: splitStateWaiting}
+		c.sessions[c.nextID] = c.open
+		c.nextID++
+	}
+	session := c.open
+	session.participants = append(session.participants, &req)
+	session.secrets = append(session.secrets, secret)
+	session.pkScripts = append(session.pkScripts, pkScript)
+	resp := &splitJoinResponse{
+		Session: session.id,
+		Index:   len(session.participants) - 1,
+		Secret:  secret,
+	}
+	log.Infof("Participant %v joined split ticket session %v contributing %v",
+		resp.Index, session.id, dcrutil.Amount(req.Contribution))
+
+	c.buildOpen()
+
+	writeJSON(w, resp)
+}
+
+// buildOpen tries to build the split ticket of the open session, failing
+// the session if it can not be built. The mutex must be held.
+func (c *splitCoordinator) buildOpen() {
+	session := c.open
+	if session == nil {
+		return
+	}
+	if err := c.tryBuild(session); err != nil {
+		log.Errorf("Failed to build split ticket for session %v: %v",
+			session.id, err)
+		session.state = splitStateFailed
+		session.err = err.Error()
+		c.open = nil
+	}
+}
+
+// tryBuild builds the split ticket for the session if the contributions
+// cover the current ticket price and fee. The ticket price, fee rate,
+// expiry and voting address are those passed to blockConnected by the
+// purchase round, so that no RPCs are made while the mutex is held and
+// nothing is built before the first block is connected. The mutex must be
+// held.
+func (c *splitCoordinator) tryBuild(session *splitSession) error {
+	if c.height == 0 {
+		log.Debugf("Not building split ticket session %v before the "+
+			"first block is connected", session.id)
+		return nil
+	}
+	size := splitTicketBaseSize +
+		splitTicketParticipantSize*len(session.participants)
+	fee := c.feeRate * dcrutil.Amount(size) / 1000
+
+	total := dcrutil.Amount(0)
+	for _, p := range session.participants {
+		total += dcrutil.Amount(p.Contribution)
+	}
+	if total < c.ticketPrice+fee {
+		log.Debugf("Split ticket session %v has %v of %v contributed",
+			session.id, total, c.ticketPrice+fee)
+		return nil
+	}
+	if c.ticketAddress == nil {
+		log.Debugf("Not building split ticket session %v until a voting "+
+			"address is fetched at the next block", session.id)
+		return nil
+	}
+
+	var err error
+	session.tx, err = buildSplitTicket(session.participants, c.ticketAddress,
+		c.ticketPrice, fee, uint32(c.expiry))
+	if err != nil {
+		return err
+	}
+	session.state = splitStateSigning
+	session.signed = make([]bool, len(session.participants))
+	session.builtHeight = c.height
+	session.ticketPrice = c.ticketPrice
+	c.open = nil
+
+	// Each split ticket votes with a new address from the wallet unless
+	// a ticket address is configured.
+	if c.purchaser.ticketAddress == nil {
+		c.ticketAddress = nil
+	}
+
+	log.Infof("Built split ticket for session %v with %v participants at "+
+		"stake difficulty %v (fee %v), waiting for signatures", session.id,
+		len(session.participants), c.ticketPrice, fee)
+
+	return nil
+}
+
+// needsTicketAddress returns whether the coordinator has no voting address
+// for the next split ticket it builds.
+func (c *splitCoordinator) needsTicketAddress() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.ticketAddress == nil
+}
+
+// handleStatus returns the state of a session.
+func (c *splitCoordinator) handleStatus(w http.ResponseWriter,
+	r *http.Request) {
+	var id int
+	if _, err := fmt.Sscan(r.URL.Query().Get("session"), &id); err != nil {
+		writeJSON(w, &splitStatusResponse{Error: err.Error()})
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	session, ok := c.sessions[id]
+	if !ok {
+		writeJSON(w, &splitStatusResponse{Error: "unknown session"})
+		return
+	}
+	resp := &splitStatusResponse{State: session.state, Error: session.err}
+	if session.state == splitStateSigning {
+		txHex, err := serializeTx(session.tx)
+		if err != nil {
+			writeJSON(w, &splitStatusResponse{Error: err.Error()})
+			return
+		}
+		resp.Tx = txHex
+	}
+	if session.ticket != nil {
+		resp.Ticket = session.ticket.String()
+	}
+
+	writeJSON(w, resp)
+}
+
+// handleSign merges the signature of a participant's input into the split
+// ticket, publishing it once all inputs are signed. The request must carry
+// the secret returned to the participant at the index when it joined, the
+// input must not already be signed and the signature must be valid for
+// the output the input spends.
+func (c *splitCoordinator) handleSign(w http.ResponseWriter, r *http.Request) {
+	var req splitSignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, &splitStatusResponse{Error: err.Error()})
+		return
+	}
+	signedTx, err := deserializeTx(req.Tx)
+	if err != nil {
+		writeJSON(w, &splitStatusResponse{Error: err.Error()})
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	session, ok := c.sessions[req.Session]
+	if !ok || session.state != splitStateSigning {
+		writeJSON(w, &splitStatusResponse{Error: "session is not signing"})
+		return
+	}
+	if req.Index < 0 || req.Index >= len(session.tx.TxIn) ||
+		len(signedTx.TxIn) != len(session.tx.TxIn) {
+		writeJSON(w, &splitStatusResponse{Error: "invalid signature"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Secret),
+		[]byte(session.secrets[req.Index])) != 1 {
+		writeJSON(w, &splitStatusResponse{Error: "invalid secret"})
+		return
+	}
+	if session.signed[req.Index] {
+		writeJSON(w, &splitStatusResponse{Error: "input already signed"})
+		return
+	}
+	txIn := session.tx.TxIn[req.Index]
+	txIn.SignatureScript = signedTx.TxIn[req.Index].SignatureScript
+	err = verifyInputSignature(session.tx, req.Index,
+		session.pkScripts[req.Index])
+	if err != nil {
+		txIn.SignatureScript = nil
+		writeJSON(w, &splitStatusResponse{
+			Error: fmt.Sprintf("invalid signature: %v", err),
+		})
+		return
+	}
+	session.signed[req.Index] = true
+
+	for _, signed := range session.signed {
+		if !signed {
+			writeJSON(w, &splitStatusResponse{State: session.state})
+			return
+		}
+	}
+
+	ticket, err := c.purchaser.dcrdChainSvr.SendRawTransaction(session.tx,
+		false)
+	if err != nil {
+		log.Errorf("Failed to publish split ticket for session %v: %v",
+			session.id, err)
+		session.state = splitStateFailed
+		session.err = err.Error()
+	} else {
+		log.Infof("Published split ticket %v for session %v at stake "+
+			"difficulty %v", ticket, session.id, session.ticketPrice)
+		session.state = splitStatePublished
+		session.ticket = ticket
+	}
+
+	writeJSON(w, &splitStatusResponse{State: session.state, Error: session.err})
+}
+
+// blockConnected records the ticket price, fee per KB and expiry to build
+// split tickets with until the next block, along with the voting address
+// of the next split ticket if it is not nil. It then abandons sessions that
+// were built at a stake difficulty that is no longer valid or have waited
+// too long for signatures, forgets sessions that have finished and tries
+// to build the open session at the new stake difficulty. It is called by
+// the purchase round, which owns the fee and expiry state and makes the
+// RPCs to fetch the rest.
+func (c *splitCoordinator) blockConnected(height int32, ticketPrice,
+	feeRate dcrutil.Amount, expiry int32, ticketAddress dcrutil.Address) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.height = height
+	c.ticketPrice = ticketPrice
+	c.feeRate = feeRate
+	c.expiry = expiry
+	if ticketAddress != nil {
+		c.ticketAddress = ticketAddress
+	}
+
+	winSize := int32(activeNet.StakeDiffWindowSize)
+	for id, session := range c.sessions {
+		switch session.state {
+		case splitStateSigning:
+			newWindow := (height+1)/winSize != (session.builtHeight+1)/winSize
+			if newWindow || height-session.builtHeight >
+				splitSessionTimeoutBlocks {
+				log.Warnf("Abandoning split ticket session %v that "+
+					"was not signed in time", id)
+				session.state = splitStateFailed
+				session.err = "session expired"
+			}
+		case splitStatePublished, splitStateFailed:
+			if height-session.builtHeight > splitSessionTimeoutBlocks*2 {
+				delete(c.sessions, id)
+			}
+		}
+	}
+
+	c.buildOpen()
+}
+
+// splitParticipant contributes to split tickets built by a coordinator,
+// one session at a time.
+type splitParticipant struct {
+	purchaser   *ticketPurchaser
+	coordinator string
+	client      *http.Client
+
+	session int
+	index   int
+	secret  string
+	joined  bool
+	signed  bool
+	request *splitJoinRequest
+	outPt   *wire.OutPoint
+}
+
+// newSplitParticipant creates a new splitParticipant.
+func newSplitParticipant(purchaser *ticketPurchaser,
+	coordinator string) *splitParticipant {
+	return &splitParticipant{
+		purchaser:   purchaser,
+		coordinator: coordinator,
+		client:      &http.Client{Timeout: splitClientTimeout},
+	}
+}
+
+// post sends a JSON request to the coordinator and decodes the response.
+func (p *splitParticipant) post(path string, req, resp interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := p.client.Post("http://"+p.coordinator+path,
+		"application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+// reset forgets the current session, unlocking the funding output if it
+// was not spent.
+func (p *splitParticipant) reset(unlock bool) {
+	if unlock && p.outPt != nil {
+		err := p.purchaser.dcrwChainSvr.LockUnspent(true,
+			[]*wire.OutPoint{p.outPt})
+		if err != nil {
+			log.Errorf("Failed to unlock split ticket input %v: %v",
+				p.outPt, err)
+		}
+	}
+	p.joined = false
+	p.signed = false
+	p.secret = ""
+	p.request = nil
+	p.outPt = nil
+}
+
+// join selects an output to fund the contribution and joins the open
+// session of the coordinator.
+func (p *splitParticipant) join() error {
+	t := p.purchaser
+	contribution, err := dcrutil.NewAmount(t.cfg.SplitAmount)
+	if err != nil {
+		return err
+	}
+
+	balSpendable, err := t.dcrwChainSvr.GetBalanceMinConfType(t.cfg.AccountName,
+		1, "spendable")
+	if err != nil {
+		return err
+	}
+	if balSpendable.ToCoin()-contribution.ToCoin() < t.cfg.BalanceToMaintain {
+		log.Tracef("Not joining a split ticket because our balance %v "+
+			"is too low to contribute %v", balSpendable, contribution)
+		return nil
+	}
+
+	// Select the smallest confirmed output in the account that can fund
+	// the contribution.
+	unspent, err := t.dcrwChainSvr.ListUnspentMin(1)
+	if err != nil {
+		return err
+	}
+	var input *splitInput
+	for i := range unspent {
+		u := &unspent[i]
+		if u.Account != t.cfg.AccountName || !u.Spendable {
+			continue
+		}
+		amt, err := dcrutil.NewAmount(u.Amount)
+		if err != nil {
+			return err
+		}
+		if amt < contribution || (input != nil && int64(amt) >= input.Amount) {
+			continue
+		}
+		input = &splitInput{
+			TxID:   u.TxID,
+			Vout:   u.Vout,
+			Tree:   u.Tree,
+			Amount: int64(amt),
+		}
+	}
+	if input == nil {
+		log.Debugf("No confirmed output large enough to contribute %v to a "+
+			"split ticket", contribution)
+		return nil
+	}
+
+	commitAddr, err := t.dcrwChainSvr.GetNewAddress(t.cfg.AccountName)
+	if err != nil {
+		return err
+	}
+	changeAddr, err := t.dcrwChainSvr.GetRawChangeAddress(t.cfg.AccountName)
+	if err != nil {
+		return err
+	}
+
+	// Lock the output so that the wallet does not spend it while the
+	// split ticket is being assembled.
+	hash, err := chainhash.NewHashFromStr(input.TxID)
+	if err != nil {
+		return err
+	}
+	outPt := wire.NewOutPoint(hash, input.Vout, input.Tree)
+	err = t.dcrwChainSvr.LockUnspent(false, []*wire.OutPoint{outPt})
+	if err != nil {
+		return err
+	}
+	p.outPt = outPt
+
+	req := &splitJoinRequest{
+		Contribution:  int64(contribution),
+		Input:         *input,
+		CommitAddress: commitAddr.EncodeAddress(),
+		ChangeAddress: changeAddr.EncodeAddress(),
+	}
+	var resp splitJoinResponse
+	if err := p.post("/split/join", req, &resp); err != nil {
+		p.reset(true)
+		return err
+	}
+	if resp.Error != "" {
+		p.reset(true)
+		return fmt.Errorf("coordinator refused to join: %v", resp.Error)
+	}
+
+	p.joined = true
+	p.session = resp.Session
+	p.index = resp.Index
+	p.secret = resp.Secret
+	p.request = req
+	log.Infof("Joined split ticket session %v contributing %v", p.session,
+		contribution)
+
+	return nil
+}
+
+// sign verifies the split ticket built by the coordinator, signs our input
+// with the wallet and sends the signature back.
+func (p *splitParticipant) sign(txHex string) error {
+	t := p.purchaser
+	mtx, err := deserializeTx(txHex)
+	if err != nil {
+		return err
+	}
+	maxPriceAbsAmt, err := dcrutil.NewAmount(t.cfg.MaxPriceAbsolute)
+	if err != nil {
+		return err
+	}
+	if err := verifySplitTicket(mtx, p.index, p.request,
+		maxPriceAbsAmt); err != nil {
+		return err
+	}
+
+	// The wallet only signs the inputs it owns, which is our input.
+	var signedTx *wire.MsgTx
+	err = t.withUnlockedWallet(func() error {
+		var err error
+		signedTx, _, err = t.dcrwChainSvr.SignRawTransaction(mtx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	txHex, err = serializeTx(signedTx)
+	if err != nil {
+		return err
+	}
+
+	var resp splitStatusResponse
+	err = p.post("/split/sign", &splitSignRequest{
+		Session: p.session,
+		Index:   p.index,
+		Secret:  p.secret,
+		Tx:      txHex,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("coordinator refused signature: %v", resp.Error)
+	}
+	p.signed = true
+	log.Infof("Signed split ticket for session %v", p.session)
+
+	return nil
+}
+
+// process advances the participant's split ticket session by one step.
+func (p *splitParticipant) process() error {
+	if !p.joined {
+		return p.join()
+	}
+
+	// Unlock the funding output if the coordinator can not be reached,
+	// since the session can not be completed without it.
+	r, err := p.client.Get(fmt.Sprintf("http://%s/split/status?session=%d",
+		p.coordinator, p.session))
+	if err != nil {
+		p.reset(true)
+		return err
+	}
+	defer r.Body.Close()
+	var status splitStatusResponse
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		p.reset(true)
+		return err
+	}
+	if status.Error != "" && status.State == "" {
+		p.reset(true)
+		return fmt.Errorf("split ticket session %v: %v", p.session,
+			status.Error)
+	}
+
+	switch status.State {
+	case splitStateSigning:
+		if !p.signed {
+			if err := p.sign(status.Tx); err != nil {
+				return err
+			}
+		}
+	case splitStatePublished:
+		log.Infof("Purchased split ticket %v with a contribution of %v",
+			status.Ticket, dcrutil.Amount(p.request.Contribution))
+		p.reset(false)
+	case splitStateFailed:
+		log.Warnf("Split ticket session %v failed: %v", p.session,
+			status.Error)
+		p.reset(true)
+	}
+
+	return nil
+}
+
+// processSplitTickets advances the split ticket coordinator or participant
+// at each connected block.
+func (t *ticketPurchaser) processSplitTickets(height int32) error {
+	if c := t.splitCoordinator; c != nil {
+		stakeDiffs, err := t.dcrdChainSvr.GetStakeDifficulty()
+		if err != nil {
+			return err
+		}
+		ticketPrice, err := dcrutil.NewAmount(stakeDiffs.NextStakeDifficulty)
+		if err != nil {
+			return err
+		}
+		feeRate := t.ticketFee
+		if feeRate == 0 {
+			feeRate, err = dcrutil.NewAmount(t.cfg.MinFee)
+			if err != nil {
+				return err
+			}
+		}
+		expiry, err := t.ticketExpiry(height)
+		if err != nil {
+			return err
+		}
+
+		// Only fetch a new voting address once the last one was used,
+		// so that no address is wasted at each block.
+		ticketAddress := t.ticketAddress
+		if ticketAddress == nil && c.needsTicketAddress() {
+			ticketAddress, err =
+				t.dcrwChainSvr.GetRawChangeAddress(t.cfg.AccountName)
+			if err != nil {
+				return err
+			}
+		}
+		c.blockConnected(height, ticketPrice, feeRate, expiry,
+			ticketAddress)
+	}
+	if t.splitParticipant != nil {
+		return t.splitParticipant.process()
+	}
+	return nil
+}
@@ -0,0 +1,450 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cjepson/dcrticketbuyer/rpctest"
+	"github.com/decred/dcrd/blockchain/stake"
+	"github.com/decred/dcrd/chaincfg/chainec"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrutil"
+)
+
+// testAddress returns a pay-to-pubkey-hash address on the active network
+// whose hash is filled with b.
+func testAddress(t *testing.T, b byte) dcrutil.Address {
+	addr, err := dcrutil.NewAddressPubKeyHash(bytes.Repeat([]byte{b}, 20),
+		activeNet.Params, chainec.ECTypeSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+// testParticipant returns the join request of participant n contributing
+// contribution from an input of amount.
+func testParticipant(t *testing.T, n byte, contribution,
+	amount dcrutil.Amount) *splitJoinRequest {
+	return &splitJoinRequest{
+		Contribution: int64(contribution),
+		Input: splitInput{
+			TxID:   testTxID(n),
+			Vout:   uint32(n),
+			Amount: int64(amount),
+		},
+		CommitAddress: testAddress(t, 2*n).EncodeAddress(),
+		ChangeAddress: testAddress(t, 2*n+1).EncodeAddress(),
+	}
+}
+
+// TestSplitCommitments ensures the ticket price and fee are divided in
+// proportion to the contributions, with the remainder from rounding
+// assigned to the last participant.
+func TestSplitCommitments(t *testing.T) {
+	tests := []struct {
+		name          string
+		contributions []dcrutil.Amount
+		total         dcrutil.Amount
+		want          []dcrutil.Amount
+	}{
+		{"single participant", []dcrutil.Amount{5e8}, 3e8,
+			[]dcrutil.Amount{3e8}},
+		{"equal contributions", []dcrutil.Amount{5e8, 5e8}, 8e8,
+			[]dcrutil.Amount{4e8, 4e8}},
+		{"proportional", []dcrutil.Amount{1e8, 3e8}, 2e8,
+			[]dcrutil.Amount{5e7, 15e7}},
+		{"remainder to the last participant", []dcrutil.Amount{1, 1, 1}, 10,
+			[]dcrutil.Amount{3, 3, 4}},
+	}
+
+	for _, test := range tests {
+		got := splitCommitments(test.contributions, test.total)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got commitments %v, want %v", test.name, got,
+				test.want)
+		}
+	}
+}
+
+// TestBuildSplitTicket ensures the split ticket pays the ticket price to
+// the voting address, spends the input of each participant and commits its
+// share of the ticket price and fee with the rest of its input as change.
+func TestBuildSplitTicket(t *testing.T) {
+	const (
+		price = dcrutil.Amount(10e8)
+		fee   = dcrutil.Amount(1e6)
+	)
+	invalid := testParticipant(t, 2, 6e8, 10e8)
+	invalid.CommitAddress = "invalid"
+	tests := []struct {
+		name         string
+		participants []*splitJoinRequest
+		wantCommits  []dcrutil.Amount
+		wantErr      bool
+	}{
+		{
+			name: "two participants",
+			participants: []*splitJoinRequest{
+				testParticipant(t, 1, 6e8, 8e8),
+				testParticipant(t, 2, 6e8, 10e8),
+			},
+			wantCommits: []dcrutil.Amount{5005e5, 5005e5},
+		},
+		{
+			name: "uneven contributions",
+			participants: []*splitJoinRequest{
+				testParticipant(t, 1, 2e8, 3e8),
+				testParticipant(t, 2, 6e8, 10e8),
+				testParticipant(t, 3, 2e8, 3e8),
+			},
+			wantCommits: []dcrutil.Amount{2002e5, 6006e5, 2002e5},
+		},
+		{
+			name: "input too small for its commitment",
+			participants: []*splitJoinRequest{
+				testParticipant(t, 1, 3e8, 4e8),
+				testParticipant(t, 2, 3e8, 8e8),
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid commitment address",
+			participants: []*splitJoinRequest{
+				testParticipant(t, 1, 6e8, 8e8),
+				invalid,
+			},
+			wantErr: true,
+		},
+	}
+
+	ticketAddress := testAddress(t, 0)
+	for _, test := range tests {
+		mtx, err := buildSplitTicket(test.participants, ticketAddress, price,
+			fee, 1000)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: built a split ticket, want an error",
+					test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		n := len(test.participants)
+		if len(mtx.TxIn) != n || len(mtx.TxOut) != 1+2*n {
+			t.Errorf("%s: got %v inputs and %v outputs, want %v and %v",
+				test.name, len(mtx.TxIn), len(mtx.TxOut), n, 1+2*n)
+			continue
+		}
+		if mtx.Expiry != 1000 {
+			t.Errorf("%s: got expiry %v, want 1000", test.name, mtx.Expiry)
+		}
+		if dcrutil.Amount(mtx.TxOut[0].Value) != price {
+			t.Errorf("%s: got ticket price %v, want %v", test.name,
+				dcrutil.Amount(mtx.TxOut[0].Value), price)
+		}
+		for i, p := range test.participants {
+			prevOut := mtx.TxIn[i].PreviousOutPoint
+			if prevOut.Hash.String() != p.Input.TxID ||
+				prevOut.Index != p.Input.Vout {
+				t.Errorf("%s: input %v spends %v, want %v:%v", test.name,
+					i, prevOut, p.Input.TxID, p.Input.Vout)
+			}
+			commit, err := stake.AmountFromSStxPkScrCommitment(
+				mtx.TxOut[1+2*i].PkScript)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+				continue
+			}
+			if commit != test.wantCommits[i] {
+				t.Errorf("%s: got commitment %v for participant %v, "+
+					"want %v", test.name, commit, i, test.wantCommits[i])
+			}
+			change := mtx.TxOut[2+2*i].Value
+			if change != p.Input.Amount-int64(commit) {
+				t.Errorf("%s: got change %v for participant %v, want %v",
+					test.name, dcrutil.Amount(change), i,
+					dcrutil.Amount(p.Input.Amount)-commit)
+			}
+		}
+	}
+}
+
+// TestVerifySplitTicket ensures a participant only accepts a split ticket
+// that spends its input, is not above its maximum price, commits no more
+// than its contribution to its commitment address and returns the rest of
+// its input to its change address.
+func TestVerifySplitTicket(t *testing.T) {
+	tests := []struct {
+		name        string
+		index       int
+		participant int
+		maxPrice    dcrutil.Amount
+		modify      func(mtx *wire.MsgTx, req *splitJoinRequest)
+		wantErr     bool
+	}{
+		{name: "first participant", index: 0, participant: 0,
+			maxPrice: 20e8},
+		{name: "second participant", index: 1, participant: 1,
+			maxPrice: 20e8},
+		{name: "price at the maximum", index: 0, participant: 0,
+			maxPrice: 10e8},
+		{name: "price above the maximum", index: 0, participant: 0,
+			maxPrice: 9e8, wantErr: true},
+		{name: "index out of range", index: 2, participant: 1,
+			maxPrice: 20e8, wantErr: true},
+		{name: "another participant's input", index: 0, participant: 1,
+			maxPrice: 20e8, wantErr: true},
+		{
+			name:        "extra output",
+			index:       0,
+			participant: 0,
+			maxPrice:    20e8,
+			modify: func(mtx *wire.MsgTx, req *splitJoinRequest) {
+				mtx.AddTxOut(wire.NewTxOut(1, nil))
+			},
+			wantErr: true,
+		},
+		{
+			name:        "commitment to another address",
+			index:       0,
+			participant: 0,
+			maxPrice:    20e8,
+			modify: func(mtx *wire.MsgTx, req *splitJoinRequest) {
+				req.CommitAddress = testAddress(t, 9).EncodeAddress()
+			},
+			wantErr: true,
+		},
+		{
+			name:        "commitment above the contribution",
+			index:       0,
+			participant: 0,
+			maxPrice:    20e8,
+			modify: func(mtx *wire.MsgTx, req *splitJoinRequest) {
+				req.Contribution = 4e8
+			},
+			wantErr: true,
+		},
+		{
+			name:        "change to another address",
+			index:       1,
+			participant: 1,
+			maxPrice:    20e8,
+			modify: func(mtx *wire.MsgTx, req *splitJoinRequest) {
+				req.ChangeAddress = testAddress(t, 9).EncodeAddress()
+			},
+			wantErr: true,
+		},
+		{
+			name:        "change withheld",
+			index:       0,
+			participant: 0,
+			maxPrice:    20e8,
+			modify: func(mtx *wire.MsgTx, req *splitJoinRequest) {
+				mtx.TxOut[2].Value -= 1e8
+			},
+			wantErr: true,
+		},
+	}
+
+	ticketAddress := testAddress(t, 0)
+	for _, test := range tests {
+		participants := []*splitJoinRequest{
+			testParticipant(t, 1, 6e8, 8e8),
+			testParticipant(t, 2, 6e8, 10e8),
+		}
+		mtx, err := buildSplitTicket(participants, ticketAddress, 10e8, 1e6,
+			1000)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		req := participants[test.participant]
+		if test.modify != nil {
+			test.modify(mtx, req)
+		}
+
+		err = verifySplitTicket(mtx, test.index, req, test.maxPrice)
+		if test.wantErr && err == nil {
+			t.Errorf("%s: verified the split ticket, want an error",
+				test.name)
+		}
+		if !test.wantErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
+
+// newSplitPurchaser connects a purchaser configured by cfg to the fakes of
+// a test harness without starting a purchase manager, and returns it with
+// a function that disconnects its RPC clients.
+func newSplitPurchaser(t *testing.T, h *rpctest.Harness,
+	cfg *config) (*ticketPurchaser, func()) {
+	dcrdClient, err := connectDaemon(cfg, make(chan int32,
+		blockConnChanBuffer))
+	if err != nil {
+		t.Fatalf("failed to connect to the fake dcrd: %v", err)
+	}
+	dcrwClient, err := connectWallet(cfg)
+	if err != nil {
+		dcrdClient.Shutdown()
+		t.Fatalf("failed to connect to the fake dcrwallet: %v", err)
+	}
+	shutdown := func() {
+		dcrdClient.Shutdown()
+		dcrwClient.Shutdown()
+	}
+	if errs := checkCompatibility(cfg, dcrdClient, dcrwClient); len(errs) != 0 {
+		shutdown()
+		t.Fatalf("fakes are incompatible: %v", errs)
+	}
+	p, err := newTicketPurchaser(cfg, dcrdClient, dcrwClient, nil)
+	if err != nil {
+		shutdown()
+		t.Fatalf("failed to create purchaser: %v", err)
+	}
+	return p, shutdown
+}
+
+// TestSplitTicketRoundTrip ensures two participants contributing 6 coins
+// each join a coordinator's session, that the split ticket is built at the
+// stake difficulty of 10 coins once they have both joined, that only valid
+// signatures from the participant holding an input's secret are accepted,
+// and that the ticket is published once both inputs are signed.
+func TestSplitTicketRoundTrip(t *testing.T) {
+	h := newTestHarness(t, 300)
+	defer h.Close()
+
+	cfg := newTestConfig(h)
+	cfg.SplitMode = splitCoordinatorStr
+	purchaser, shutdown := newSplitPurchaser(t, h, cfg)
+	defer shutdown()
+	c := purchaser.splitCoordinator
+	srv := httptest.NewServer(c.handler())
+	defer srv.Close()
+
+	var participants []*splitParticipant
+	for i := 0; i < 2; i++ {
+		if _, err := h.Wallet.AddUnspent(8); err != nil {
+			t.Fatal(err)
+		}
+		cfg := newTestConfig(h)
+		cfg.SplitMode = splitParticipantStr
+		cfg.SplitCoordinator = strings.TrimPrefix(srv.URL, "http://")
+		cfg.SplitAmount = 6
+		purchaser, shutdown := newSplitPurchaser(t, h, cfg)
+		defer shutdown()
+		participants = append(participants, purchaser.splitParticipant)
+	}
+	first, second := participants[0], participants[1]
+
+	if err := purchaser.processSplitTickets(301); err != nil {
+		t.Fatalf("coordinator failed: %v", err)
+	}
+	for i, p := range participants {
+		if err := p.process(); err != nil {
+			t.Fatalf("participant %v failed to join: %v", i, err)
+		}
+		if !p.joined || p.index != i {
+			t.Fatalf("participant %v joined at index %v, want %v", i,
+				p.index, i)
+		}
+		input := p.request.Input
+		if !h.Wallet.Locked(input.TxID, input.Vout) {
+			t.Errorf("participant %v did not lock its input", i)
+		}
+	}
+	if first.request.Input.TxID == second.request.Input.TxID {
+		t.Fatalf("both participants contributed the same input")
+	}
+
+	c.mtx.Lock()
+	session := c.sessions[first.session]
+	state := session.state
+	c.mtx.Unlock()
+	if state != splitStateSigning {
+		t.Fatalf("got session state %v after both joined, want %v", state,
+			splitStateSigning)
+	}
+
+	if err := first.process(); err != nil {
+		t.Fatalf("first participant failed to sign: %v", err)
+	}
+	if !first.signed {
+		t.Fatalf("first participant did not sign")
+	}
+
+	// Signatures that are repeated, sent with another participant's
+	// secret or invalid are refused.
+	c.mtx.Lock()
+	txHex, err := serializeTx(session.tx)
+	c.mtx.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := []struct {
+		name    string
+		index   int
+		secret  string
+		wantErr string
+	}{
+		{"signed again", 0, first.secret, "input already signed"},
+		{"another participant's secret", 1, first.secret, "invalid secret"},
+		{"missing signature", 1, second.secret, "invalid signature"},
+	}
+	for _, test := range refused {
+		var resp splitStatusResponse
+		err := first.post("/split/sign", &splitSignRequest{
+			Session: first.session,
+			Index:   test.index,
+			Secret:  test.secret,
+			Tx:      txHex,
+		}, &resp)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if !strings.Contains(resp.Error, test.wantErr) {
+			t.Errorf("%s: got error %q, want %q", test.name, resp.Error,
+				test.wantErr)
+		}
+	}
+
+	if err := second.process(); err != nil {
+		t.Fatalf("second participant failed to sign: %v", err)
+	}
+	published := h.Dcrd.Published()
+	if len(published) != 1 {
+		t.Fatalf("got %v published transactions, want 1", len(published))
+	}
+	ticket := published[0]
+	if ticket.TxOut[0].Value != 10e8 {
+		t.Errorf("got ticket price %v, want 10 coins",
+			dcrutil.Amount(ticket.TxOut[0].Value))
+	}
+	for i := range ticket.TxIn {
+		err := verifyInputSignature(ticket, i, session.pkScripts[i])
+		if err != nil {
+			t.Errorf("input %v of the published ticket is not signed: %v",
+				i, err)
+		}
+	}
+
+	for i, p := range participants {
+		if err := p.process(); err != nil {
+			t.Fatalf("participant %v failed: %v", i, err)
+		}
+		if p.joined {
+			t.Errorf("participant %v is still in the published session", i)
+		}
+	}
+}
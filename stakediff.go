@@ -0,0 +1,249 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/decred/dcrd/dcrjson"
+	"github.com/decred/dcrrpcclient"
+	"github.com/decred/dcrutil"
+)
+
+var (
+	// useLocalStakeDiffStr is the string indicating that the local
+	// stake difficulty forecaster should be used in place of the
+	// estimatestakediff RPC.
+	useLocalStakeDiffStr = "local"
+)
+
+// stakeDiffForecaster models the stake difficulty of the next window
+// locally using the consensus retarget algorithm from the active network
+// parameters. Historical per block information that can not change is
+// cached, so that only a handful of RPC calls are needed each round.
+type stakeDiffForecaster struct {
+	dcrdChainSvr  *dcrrpcclient.Client
+	poolSizeCache map[int64]uint32
+}
+
+// newStakeDiffForecaster creates a new stakeDiffForecaster.
+func newStakeDiffForecaster(dcrdChainSvr *dcrrpcclient.Client) *stakeDiffForecaster {
+	return &stakeDiffForecaster{
+		dcrdChainSvr:  dcrdChainSvr,
+		poolSizeCache: make(map[int64]uint32),
+	}
+}
+
+// poolSizeAt returns the ticket pool size at the given height. Heights
+// below the current height are cached since they never change unless
+// there is a reorganization deeper than a window.
+func (f *stakeDiffForecaster) poolSizeAt(height int64) (uint32, error) {
+	if poolSize, ok := f.poolSizeCache[height]; ok {
+		return poolSize, nil
+	}
+
+	blH, err := f.dcrdChainSvr.GetBlockHash(height)
+	if err != nil {
+		return 0, err
+	}
+	bl, err := f.dcrdChainSvr.GetBlock(blH)
+	if err != nil {
+		return 0, err
+	}
+	poolSize := bl.MsgBlock().Header.PoolSize
+	f.poolSizeCache[height] = poolSize
+
+	return poolSize, nil
+}
+
+// prune removes cached pool sizes that are below the oldest height that
+// will be needed for future forecasts.
+func (f *stakeDiffForecaster) prune(minHeight int64) {
+	for height := range f.poolSizeCache {
+		if height < minHeight {
+			delete(f.poolSizeCache, height)
+		}
+	}
+}
+
+// weightedWindowChange calculates the exponentially weighted average of the
+// per window ratios, where the first window is the most recent one and is
+// weighted the heaviest. This mirrors the fixed point calculation done in
+// consensus, but in floating point.
+func weightedWindowChange(ratios []float64) float64 {
+	alpha := float64(activeNet.StakeDiffAlpha)
+	windows := float64(len(ratios))
+	weightedSum, weights := 0.0, 0.0
+	for i, ratio := range ratios {
+		weight := math.Pow(2, (windows-float64(i))*alpha)
+		weightedSum += ratio * weight
+		weights += weight
+	}
+
+	return weightedSum / weights
+}
+
+// clampRetarget restricts the next difficulty to the maximum allowable
+// retarget from the old difficulty.
+func clampRetarget(oldDiff, nextDiff float64) float64 {
+	maxRetarget := float64(activeNet.RetargetAdjustmentFactor)
+	switch {
+	case oldDiff == 0:
+		return nextDiff
+	case nextDiff > oldDiff*maxRetarget:
+		return oldDiff * maxRetarget
+	case nextDiff < oldDiff/maxRetarget:
+		return oldDiff / maxRetarget
+	}
+
+	return nextDiff
+}
+
+// nextDifficulty calculates the next window stake difficulty in atoms from
+// the old difficulty, the pool sizes at the end of each window and the fresh
+// stake purchased in each window. The first element of each slice is the
+// most recent window.
+func nextDifficulty(oldDiff float64, poolSizes []float64,
+	freshStake []float64) float64 {
+	targetForTicketPool := float64(activeNet.TicketsPerBlock) *
+		float64(activeNet.TicketPoolSize)
+	targetForWindow := float64(activeNet.StakeDiffWindowSize) *
+		float64(activeNet.TicketsPerBlock)
+	poolWeight := float64(activeNet.TicketPoolSizeWeight)
+
+	// Calculate the difficulty change based on the ticket pool size.
+	poolRatios := make([]float64, len(poolSizes))
+	for i, poolSize := range poolSizes {
+		poolSizeSkew := (poolSize-targetForTicketPool)*poolWeight +
+			targetForTicketPool
+		if poolSizeSkew <= 0 {
+			poolSizeSkew = 1
+		}
+		poolRatios[i] = poolSizeSkew / targetForTicketPool
+	}
+	nextDiffTicketPool := clampRetarget(oldDiff,
+		weightedWindowChange(poolRatios)*oldDiff)
+
+	// Calculate the difficulty change based on the fresh stake.
+	freshRatios := make([]float64, len(freshStake))
+	for i, fresh := range freshStake {
+		if fresh <= 0 {
+			fresh = 1
+		}
+		freshRatios[i] = fresh / targetForWindow
+	}
+	nextDiffFreshStake := clampRetarget(oldDiff,
+		weightedWindowChange(freshRatios)*oldDiff)
+
+	// Merge the two changes by multiplying their ratios.
+	nextDiff := nextDiffTicketPool
+	if oldDiff != 0 {
+		nextDiff = clampRetarget(oldDiff,
+			nextDiffTicketPool*nextDiffFreshStake/oldDiff)
+	}
+	if nextDiff < float64(activeNet.MinimumStakeDiff) {
+		nextDiff = float64(activeNet.MinimumStakeDiff)
+	}
+
+	return nextDiff
+}
+
+// forecast models the stake difficulty of the window after the one that
+// the block following height belongs to. The ticket pool size at the end
+// of the window is projected from its growth over the last window, and the
+// fresh stake is projected from the tickets purchased so far in the window
+// and the tickets currently in the mempool. The minimum assumes that no
+// further tickets are mined in the window and the maximum assumes that
+// every remaining block is filled with fresh stake, giving the confidence
+// bounds of the expected price.
+func (f *stakeDiffForecaster) forecast(height int32,
+	curDiff dcrutil.Amount) (*dcrjson.EstimateStakeDiffResult, error) {
+	winSize := activeNet.StakeDiffWindowSize
+	windows := activeNet.StakeDiffWindows
+	h := int64(height)
+
+	// The window being forecast is the one containing the next block.
+	windowStart := ((h + 1) / winSize) * winSize
+	if windowStart < winSize*windows {
+		return nil, fmt.Errorf("not enough blocks in the chain to forecast " +
+			"the stake difficulty")
+	}
+	elapsed := h + 1 - windowStart
+	remaining := winSize - elapsed
+
+	// Fetch the number of tickets purchased in each window.
+	windowsUint32 := uint32(windows)
+	info, err := f.dcrdChainSvr.TicketFeeInfo(&zeroUint32, &windowsUint32)
+	if err != nil {
+		return nil, err
+	}
+	freshByStart := make(map[int64]float64)
+	for i := range info.FeeInfoWindows {
+		start := int64(info.FeeInfoWindows[i].StartHeight)
+		freshByStart[start] = float64(info.FeeInfoWindows[i].Number)
+	}
+
+	// Project the pool size at the end of the window from its growth
+	// over the last window.
+	poolNow, err := f.poolSizeAt(h)
+	if err != nil {
+		return nil, err
+	}
+	poolPrev, err := f.poolSizeAt(h - winSize)
+	if err != nil {
+		return nil, err
+	}
+	growthPerBlock := (float64(poolNow) - float64(poolPrev)) / float64(winSize)
+	projectedPool := float64(poolNow) + growthPerBlock*float64(remaining)
+
+	// Project the fresh stake at the end of the window.
+	tiHashes, err := f.dcrdChainSvr.GetRawMempool(dcrjson.GRMTickets)
+	if err != nil {
+		return nil, err
+	}
+	inMempool := float64(len(tiHashes))
+	mined := freshByStart[windowStart]
+	maxFresh := float64(activeNet.MaxFreshStakePerBlock) * float64(remaining)
+	rateFresh := 0.0
+	if elapsed > 0 {
+		rateFresh = mined / float64(elapsed) * float64(remaining)
+	}
+	expectedFresh := math.Min(math.Max(inMempool, rateFresh), maxFresh)
+
+	// Gather the pool sizes and fresh stake of the previous windows,
+	// most recent first.
+	poolSizes := make([]float64, windows)
+	freshStake := make([]float64, windows)
+	for i := int64(1); i < windows; i++ {
+		start := windowStart - i*winSize
+		poolSize, err := f.poolSizeAt(start + winSize - 1)
+		if err != nil {
+			return nil, err
+		}
+		poolSizes[i] = float64(poolSize)
+		freshStake[i] = freshByStart[start]
+	}
+	f.prune(windowStart - winSize*windows)
+
+	estimate := func(fresh float64) float64 {
+		poolSizes[0] = projectedPool
+		freshStake[0] = mined + fresh
+		return dcrutil.Amount(nextDifficulty(float64(curDiff), poolSizes,
+			freshStake)).ToCoin()
+	}
+	result := &dcrjson.EstimateStakeDiffResult{
+		Min:      estimate(0),
+		Max:      estimate(maxFresh),
+		Expected: estimate(expectedFresh),
+	}
+
+	log.Tracef("Forecast stake difficulty for the window starting at %v: "+
+		"pool size %v (projected %.0f), tickets mined %v, tickets in "+
+		"mempool %v, expected fresh stake %.0f", windowStart+winSize, poolNow,
+		projectedPool, mined, inMempool, expectedFresh)
+
+	return result, nil
+}
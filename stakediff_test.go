@@ -0,0 +1,244 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/decred/dcrutil"
+)
+
+// TestWeightedWindowChange ensures the weighted average of the per window
+// ratios is calculated with the most recent window weighted the heaviest.
+func TestWeightedWindowChange(t *testing.T) {
+	alpha := float64(activeNet.StakeDiffAlpha)
+	tests := []struct {
+		name   string
+		ratios []float64
+		want   float64
+	}{
+		{"single window", []float64{1.5}, 1.5},
+		{"equal ratios", []float64{2, 2, 2, 2}, 2},
+		{
+			name:   "recent window heavier",
+			ratios: []float64{2, 1},
+			want: (2*math.Pow(2, 2*alpha) + 1*math.Pow(2, alpha)) /
+				(math.Pow(2, 2*alpha) + math.Pow(2, alpha)),
+		},
+	}
+
+	for _, test := range tests {
+		got := weightedWindowChange(test.ratios)
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestClampRetarget ensures the next difficulty is limited to the maximum
+// retarget in either direction.
+func TestClampRetarget(t *testing.T) {
+	factor := float64(activeNet.RetargetAdjustmentFactor)
+	tests := []struct {
+		name     string
+		oldDiff  float64
+		nextDiff float64
+		want     float64
+	}{
+		{"no old difficulty", 0, 1e9, 1e9},
+		{"within bounds", 1e9, 1.5e9, 1.5e9},
+		{"above maximum", 1e9, 1e9 * factor * 2, 1e9 * factor},
+		{"below minimum", 1e9, 1e9 / factor / 2, 1e9 / factor},
+		{"at maximum", 1e9, 1e9 * factor, 1e9 * factor},
+	}
+
+	for _, test := range tests {
+		got := clampRetarget(test.oldDiff, test.nextDiff)
+		if got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestNextDifficulty ensures the next difficulty responds to the pool size
+// and fresh stake of the previous windows.
+func TestNextDifficulty(t *testing.T) {
+	targetPool := float64(activeNet.TicketsPerBlock) *
+		float64(activeNet.TicketPoolSize)
+	targetWindow := float64(activeNet.StakeDiffWindowSize) *
+		float64(activeNet.TicketsPerBlock)
+	factor := float64(activeNet.RetargetAdjustmentFactor)
+	minDiff := float64(activeNet.MinimumStakeDiff)
+	oldDiff := 100e8
+
+	tests := []struct {
+		name       string
+		oldDiff    float64
+		poolSizes  []float64
+		freshStake []float64
+		want       float64
+	}{
+		{
+			name:       "at target",
+			oldDiff:    oldDiff,
+			poolSizes:  []float64{targetPool, targetPool},
+			freshStake: []float64{targetWindow, targetWindow},
+			want:       oldDiff,
+		},
+		{
+			name:       "full windows",
+			oldDiff:    oldDiff,
+			poolSizes:  []float64{targetPool, targetPool},
+			freshStake: []float64{targetWindow * 100, targetWindow * 100},
+			want:       oldDiff * factor,
+		},
+		{
+			name:       "empty windows",
+			oldDiff:    oldDiff,
+			poolSizes:  []float64{targetPool, targetPool},
+			freshStake: []float64{0, 0},
+			want:       oldDiff / factor,
+		},
+		{
+			name:       "minimum difficulty",
+			oldDiff:    minDiff,
+			poolSizes:  []float64{targetPool, targetPool},
+			freshStake: []float64{0, 0},
+			want:       minDiff,
+		},
+	}
+
+	for _, test := range tests {
+		got := nextDifficulty(test.oldDiff, test.poolSizes, test.freshStake)
+		if math.Abs(got-test.want) > 1 {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestStakeDiffForecast ensures the forecast of the next window stake
+// difficulty is calculated from the pool sizes, the tickets mined in each
+// window and the tickets in the mempool, and that it fails when the chain
+// is too short or an RPC fails.
+func TestStakeDiffForecast(t *testing.T) {
+	winSize := activeNet.StakeDiffWindowSize
+	windows := activeNet.StakeDiffWindows
+	h := newTestHarness(t, winSize*windows+5)
+	defer h.Close()
+	connectChan := make(chan int32, blockConnChanBuffer)
+	dcrdClient, err := connectDaemon(newTestConfig(h), connectChan)
+	if err != nil {
+		t.Fatalf("failed to connect to the fake dcrd: %v", err)
+	}
+	defer dcrdClient.Shutdown()
+	f := newStakeDiffForecaster(dcrdClient)
+
+	if _, err := f.forecast(int32(winSize*windows-2), 10e8); err == nil {
+		t.Errorf("forecast before %v windows, want an error", windows)
+	}
+
+	// Mine 10 tickets in the block at 7 blocks into the window and leave
+	// 3 in the mempool.
+	addr := testAddress(t, 1)
+	for i := uint32(0); i < 10; i++ {
+		h.Dcrd.AddMempoolTicket(newTestTicket(t, addr, i))
+	}
+	height := h.ConnectBlock()
+	for i := uint32(10); i < 13; i++ {
+		h.Dcrd.AddMempoolTicket(newTestTicket(t, addr, i))
+	}
+
+	got, err := f.forecast(int32(height), 10e8)
+	if err != nil {
+		t.Fatalf("failed to forecast: %v", err)
+	}
+
+	// The pool size does not change, so the forecast only depends on the
+	// fresh stake of the window, which is expected to be mined at the
+	// rate of the elapsed blocks.
+	elapsed := float64(height + 1 - winSize*windows)
+	remaining := float64(winSize) - elapsed
+	poolSize := float64(activeNet.TicketsPerBlock) *
+		float64(activeNet.TicketPoolSize)
+	estimate := func(fresh float64) float64 {
+		poolSizes := make([]float64, windows)
+		freshStake := make([]float64, windows)
+		for i := range poolSizes {
+			poolSizes[i] = poolSize
+		}
+		freshStake[0] = 10 + fresh
+		return dcrutil.Amount(nextDifficulty(10e8, poolSizes,
+			freshStake)).ToCoin()
+	}
+	want := []struct {
+		name      string
+		got, want float64
+	}{
+		{"min", got.Min, estimate(0)},
+		{"expected", got.Expected, estimate(10 / elapsed * remaining)},
+		{"max", got.Max, estimate(float64(activeNet.MaxFreshStakePerBlock) *
+			remaining)},
+	}
+	for _, w := range want {
+		if w.got != w.want {
+			t.Errorf("got %v forecast %v, want %v", w.name, w.got, w.want)
+		}
+	}
+	if got.Min > got.Expected || got.Expected > got.Max {
+		t.Errorf("got forecast %+v, want min <= expected <= max", got)
+	}
+
+	h.Dcrd.Fail("getrawmempool", errors.New("scripted failure"))
+	if _, err := f.forecast(int32(height), 10e8); err == nil {
+		t.Errorf("forecast with a failing getrawmempool, want an error")
+	}
+}
+
+// TestStakeDiffForecastFallback ensures a purchase round uses the local
+// forecast with the local stake difficulty source, and falls back to the
+// daemon estimate when the forecast fails.
+func TestStakeDiffForecastFallback(t *testing.T) {
+	winSize := activeNet.StakeDiffWindowSize
+	windows := activeNet.StakeDiffWindows
+	tests := []struct {
+		name   string
+		height int64
+		local  bool
+	}{
+		{"local forecast", winSize*windows + 5, true},
+		{"too few blocks", 300, false},
+	}
+
+	for _, test := range tests {
+		h := newTestHarness(t, test.height)
+		cfg := newTestConfig(h)
+		cfg.StakeDiffSource = useLocalStakeDiffStr
+		b := startTestBuyer(t, h, cfg, nil, false)
+
+		// The harness sets the daemon estimate to 15 and the next stake
+		// difficulty to 10 coins.
+		want := 15.0
+		if test.local {
+			forecast, err := b.purchaser.forecaster.forecast(
+				int32(test.height), 10e8)
+			if err != nil {
+				b.stop()
+				t.Fatalf("%s: failed to forecast: %v", test.name, err)
+			}
+			want = forecast.Expected
+		}
+		result, err := b.purchaser.purchase(int32(test.height))
+		b.stop()
+		if err != nil {
+			t.Fatalf("%s: purchase round failed: %v", test.name, err)
+		}
+		if result.EstimateExpected != want {
+			t.Errorf("%s: got expected estimate %v, want %v", test.name,
+				result.EstimateExpected, want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// queueTargetStr is the string indicating that the tickets queued for a
+// window should make up the shortfall of the live, immature and unmined
+// tickets against a target count.
+var queueTargetStr = "target"
+
+// targetToBuy returns the number of tickets to queue for a window to keep
+// targettickets tickets live, immature or in the mempool, and a
+// description of how it was found. The shortfall is spread over
+// targetwindows windows and limited to the tickets that can be afforded.
+func (t *ticketPurchaser) targetToBuy(couldBuy float64) (int, string, error) {
+	stakeInfo, err := t.dcrwChainSvr.GetStakeInfo()
+	if err != nil {
+		return 0, "", err
+	}
+	owned := int(stakeInfo.Live + stakeInfo.Immature +
+		stakeInfo.OwnMempoolTix)
+	shortfall := t.cfg.TargetTickets - owned
+	desc := fmt.Sprintf("%v tickets owned (%v live, %v immature, %v in "+
+		"mempool) of the target of %v", owned, stakeInfo.Live,
+		stakeInfo.Immature, stakeInfo.OwnMempoolTix, t.cfg.TargetTickets)
+	if shortfall <= 0 {
+		return 0, desc + ": no shortfall", nil
+	}
+
+	toBuy := int(math.Ceil(float64(shortfall) /
+		float64(t.cfg.TargetWindows)))
+	desc += fmt.Sprintf(": buying ceil(%v / %v) = %v of the shortfall "+
+		"this window", shortfall, t.cfg.TargetWindows, toBuy)
+	if float64(toBuy) > couldBuy {
+		toBuy = int(couldBuy)
+		desc += fmt.Sprintf(", limited to the %v tickets that can be "+
+			"afforded", toBuy)
+	}
+	return toBuy, desc, nil
+}
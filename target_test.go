@@ -0,0 +1,64 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/decred/dcrd/dcrjson"
+)
+
+// TestTargetToBuy ensures the shortfall against the target ticket count
+// is spread over the target windows rounding up, so that it is always
+// made up within them, and limited to the whole tickets that can be
+// afforded.
+func TestTargetToBuy(t *testing.T) {
+	tests := []struct {
+		name      string
+		stakeInfo dcrjson.GetStakeInfoResult
+		windows   int
+		couldBuy  float64
+		want      int
+	}{
+		{"at target", dcrjson.GetStakeInfoResult{Live: 10}, 1, 20, 0},
+		{"above target", dcrjson.GetStakeInfoResult{Live: 12}, 1, 20, 0},
+		{"whole shortfall", dcrjson.GetStakeInfoResult{Live: 3}, 1, 20, 7},
+		{"even split", dcrjson.GetStakeInfoResult{Live: 4}, 2, 20, 3},
+		{"rounded up", dcrjson.GetStakeInfoResult{Live: 3}, 2, 20, 4},
+		{"less than one per window", dcrjson.GetStakeInfoResult{Live: 9},
+			3, 20, 1},
+		{
+			name: "immature and mempool tickets owned",
+			stakeInfo: dcrjson.GetStakeInfoResult{Live: 2, Immature: 1,
+				OwnMempoolTix: 1},
+			windows:  4,
+			couldBuy: 20,
+			want:     2,
+		},
+		{"limited to affordable", dcrjson.GetStakeInfoResult{}, 1, 5.9, 5},
+		{"exactly affordable", dcrjson.GetStakeInfoResult{Live: 3}, 1, 7, 7},
+	}
+
+	h := newTestHarness(t, 300)
+	cfg := newTestConfig(h)
+	cfg.QueueStrategy = queueTargetStr
+	cfg.TargetTickets = 10
+	b := startTestBuyer(t, h, cfg, nil, false)
+	defer b.stop()
+
+	for _, test := range tests {
+		h.Wallet.SetStakeInfo(test.stakeInfo)
+		cfg.TargetWindows = test.windows
+		got, desc, err := b.purchaser.targetToBuy(test.couldBuy)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %v tickets (%v), want %v", test.name, got,
+				desc, test.want)
+		}
+	}
+}
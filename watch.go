@@ -0,0 +1,421 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrjson"
+	"github.com/decred/dcrd/txscript"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrrpcclient"
+	"github.com/decred/dcrutil"
+)
+
+// ticketState is the state of a watched ticket in its lifecycle.
+type ticketState int
+
+// These constants define the states of a watched ticket.
+const (
+	// ticketMempool indicates that the ticket is waiting in the mempool.
+	ticketMempool ticketState = iota
+
+	// ticketImmature indicates that the ticket has been mined but can not
+	// vote yet.
+	ticketImmature
+
+	// ticketLive indicates that the ticket is in the ticket pool and may
+	// be called to vote.
+	ticketLive
+
+	// ticketVoted indicates that the ticket has voted.
+	ticketVoted
+
+	// ticketMissed indicates that the ticket missed its vote or expired
+	// without being called to vote.
+	ticketMissed
+
+	// ticketRevoked indicates that a missed ticket has been revoked.
+	ticketRevoked
+
+	// numTicketStates is the number of ticket states.
+	numTicketStates
+)
+
+// Map of ticketState values back to their names for pretty printing.
+var ticketStateStrings = map[ticketState]string{
+	ticketMempool:  "mempool",
+	ticketImmature: "immature",
+	ticketLive:     "live",
+	ticketVoted:    "voted",
+	ticketMissed:   "missed",
+	ticketRevoked:  "revoked",
+}
+
+// String returns the ticketState as a human-readable name.
+func (s ticketState) String() string {
+	if str := ticketStateStrings[s]; str != "" {
+		return str
+	}
+	return fmt.Sprintf("Unknown ticketState (%d)", int(s))
+}
+
+// watchedTicket is a ticket paying to one of the watched addresses.
+type watchedTicket struct {
+	hash        chainhash.Hash
+	address     string
+	price       dcrutil.Amount
+	state       ticketState
+	minedHeight int64
+}
+
+// ticketWatcher tracks the tickets paying to a set of ticket addresses
+// through the mempool, immature, live, voted, missed and revoked states
+// using only dcrd.
+type ticketWatcher struct {
+	dcrdChainSvr *dcrrpcclient.Client
+	addresses    map[string]struct{}
+	tickets      map[chainhash.Hash]*watchedTicket
+}
+
+// newTicketWatcher creates a new ticketWatcher for the addresses.
+func newTicketWatcher(dcrdChainSvr *dcrrpcclient.Client,
+	addresses []string) *ticketWatcher {
+	w := &ticketWatcher{
+		dcrdChainSvr: dcrdChainSvr,
+		addresses:    make(map[string]struct{}, len(addresses)),
+		tickets:      make(map[chainhash.Hash]*watchedTicket),
+	}
+	for _, addr := range addresses {
+		w.addresses[addr] = struct{}{}
+	}
+	return w
+}
+
+// ticketAddress returns the address a ticket gives its voting rights to
+// if tx is a ticket paying to one of the watched addresses.
+func (w *ticketWatcher) ticketAddress(tx *wire.MsgTx) (string, bool) {
+	if len(tx.TxOut) == 0 {
+		return "", false
+	}
+	out := tx.TxOut[0]
+	if txscript.GetScriptClass(out.Version, out.PkScript) !=
+		txscript.StakeSubmissionTy {
+		return "", false
+	}
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(out.Version,
+		out.PkScript, activeNet.Params)
+	if err != nil || len(addrs) != 1 {
+		return "", false
+	}
+	addr := addrs[0].EncodeAddress()
+	_, ok := w.addresses[addr]
+	return addr, ok
+}
+
+// setState moves a ticket to a new state and logs the change.
+func (w *ticketWatcher) setState(ticket *watchedTicket, state ticketState) {
+	if ticket.state == state {
+		return
+	}
+	log.Infof("Ticket %v for %v: %v -> %v", ticket.hash, ticket.address,
+		ticket.state, state)
+	ticket.state = state
+}
+
+// scanBlock tracks the tickets paying to the watched addresses that were
+// mined in the block at height, and the votes and revocations spending
+// tracked tickets.
+func (w *ticketWatcher) scanBlock(height int64) error {
+	hash, err := w.dcrdChainSvr.GetBlockHash(height)
+	if err != nil {
+		return err
+	}
+	block, err := w.dcrdChainSvr.GetBlock(hash)
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range block.MsgBlock().STransactions {
+		if addr, ok := w.ticketAddress(tx); ok {
+			ticket, ok := w.tickets[tx.TxSha()]
+			if !ok {
+				ticket = &watchedTicket{
+					hash:    tx.TxSha(),
+					address: addr,
+					price:   dcrutil.Amount(tx.TxOut[0].Value),
+					state:   ticketMempool,
+				}
+				w.tickets[ticket.hash] = ticket
+			}
+			ticket.minedHeight = height
+			w.setState(ticket, ticketImmature)
+			continue
+		}
+
+		// Votes and revocations spend the ticket and pay to outputs of
+		// their own script class.
+		if len(tx.TxOut) == 0 {
+			continue
+		}
+		last := tx.TxOut[len(tx.TxOut)-1]
+		var state ticketState
+		switch txscript.GetScriptClass(last.Version, last.PkScript) {
+		case txscript.StakeGenTy:
+			state = ticketVoted
+		case txscript.StakeRevocationTy:
+			state = ticketRevoked
+		default:
+			continue
+		}
+		for _, txIn := range tx.TxIn {
+			ticket, ok := w.tickets[txIn.PreviousOutPoint.Hash]
+			if ok {
+				w.setState(ticket, state)
+			}
+		}
+	}
+
+	// Immature tickets become live once they reach ticket maturity.
+	for _, ticket := range w.tickets {
+		if ticket.state == ticketImmature &&
+			height-ticket.minedHeight >= int64(activeNet.TicketMaturity) {
+			w.setState(ticket, ticketLive)
+		}
+	}
+
+	return nil
+}
+
+// scanMempool tracks the tickets paying to the watched addresses in the
+// mempool and stops tracking those that left it without being mined.
+func (w *ticketWatcher) scanMempool() error {
+	hashes, err := w.dcrdChainSvr.GetRawMempool(dcrjson.GRMTickets)
+	if err != nil {
+		return err
+	}
+	inMempool := make(map[chainhash.Hash]struct{}, len(hashes))
+	for _, hash := range hashes {
+		inMempool[*hash] = struct{}{}
+		if _, ok := w.tickets[*hash]; ok {
+			continue
+		}
+		tx, err := w.dcrdChainSvr.GetRawTransaction(hash)
+		if err != nil {
+			return err
+		}
+		addr, ok := w.ticketAddress(tx.MsgTx())
+		if !ok {
+			continue
+		}
+		ticket := &watchedTicket{
+			hash:    *hash,
+			address: addr,
+			price:   dcrutil.Amount(tx.MsgTx().TxOut[0].Value),
+			state:   ticketMempool,
+		}
+		w.tickets[*hash] = ticket
+		log.Infof("Ticket %v for %v: %v", ticket.hash, ticket.address,
+			ticket.state)
+	}
+
+	for hash, ticket := range w.tickets {
+		if _, ok := inMempool[hash]; ticket.state == ticketMempool && !ok {
+			log.Infof("Ticket %v for %v left the mempool without being "+
+				"mined", hash, ticket.address)
+			delete(w.tickets, hash)
+		}
+	}
+
+	return nil
+}
+
+// checkMissed moves the live tracked tickets that missed their vote or
+// expired to the missed state.
+func (w *ticketWatcher) checkMissed() error {
+	live := false
+	for _, ticket := range w.tickets {
+		if ticket.state == ticketLive {
+			live = true
+			break
+		}
+	}
+	if !live {
+		return nil
+	}
+
+	missed, err := w.dcrdChainSvr.MissedTickets()
+	if err != nil {
+		return err
+	}
+	for _, hash := range missed {
+		ticket, ok := w.tickets[*hash]
+		if ok && ticket.state == ticketLive {
+			w.setState(ticket, ticketMissed)
+		}
+	}
+	return nil
+}
+
+// blockConnected updates the tracked tickets for a newly connected block
+// and reports the statistics.
+func (w *ticketWatcher) blockConnected(height int32) error {
+	if err := w.scanBlock(int64(height)); err != nil {
+		return err
+	}
+	if err := w.checkMissed(); err != nil {
+		return err
+	}
+	if err := w.scanMempool(); err != nil {
+		return err
+	}
+	w.report(height)
+	return nil
+}
+
+// rescan tracks the tickets mined to the watched addresses from height
+// from to height to, inclusive.
+func (w *ticketWatcher) rescan(from, to int64) error {
+	log.Infof("Scanning blocks %v to %v for tickets", from, to)
+	for height := from; height <= to; height++ {
+		if err := w.scanBlock(height); err != nil {
+			return err
+		}
+	}
+	return w.checkMissed()
+}
+
+// report logs the number of tracked tickets in each state and the value
+// of the immature and live tickets for each watched address.
+func (w *ticketWatcher) report(height int32) {
+	type stats struct {
+		counts [numTicketStates]int
+		locked dcrutil.Amount
+	}
+	byAddr := make(map[string]*stats, len(w.addresses))
+	for addr := range w.addresses {
+		byAddr[addr] = &stats{}
+	}
+	for _, ticket := range w.tickets {
+		s := byAddr[ticket.address]
+		s.counts[ticket.state]++
+		if ticket.state == ticketImmature || ticket.state == ticketLive {
+			s.locked += ticket.price
+		}
+	}
+
+	addrs := make([]string, 0, len(byAddr))
+	for addr := range byAddr {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		s := byAddr[addr]
+		log.Infof("Tickets for %v at height %v: %v mempool, %v immature, "+
+			"%v live, %v voted, %v missed, %v revoked (%v locked)", addr,
+			height, s.counts[ticketMempool], s.counts[ticketImmature],
+			s.counts[ticketLive], s.counts[ticketVoted],
+			s.counts[ticketMissed], s.counts[ticketRevoked], s.locked)
+	}
+}
+
+// watchRescanStart returns the height to scan the blockchain from at startup.
+// Unless fromHeight is set, the scan starts early enough to find every ticket
+// that may still be immature or live at height.
+func watchRescanStart(fromHeight, height int64) int64 {
+	if fromHeight > 0 {
+		return fromHeight
+	}
+	from := height - int64(activeNet.TicketMaturity) -
+		int64(activeNet.TicketExpiry)
+	if from < 0 {
+		from = 0
+	}
+	return from
+}
+
+// watchOnlyDcrdMethods are the dcrd RPC methods used in watch-only mode.
+var watchOnlyDcrdMethods = []string{
+	"getblock",
+	"getblockcount",
+	"getblockhash",
+	"getrawmempool",
+	"getrawtransaction",
+	"missedtickets",
+}
+
+// runWatchOnly connects to dcrd alone and tracks the tickets paying to the
+// watched addresses until interrupted.
+func runWatchOnly(cfg *config) error {
+	connectChan := make(chan int32, blockConnChanBuffer)
+	dcrdClient, err := connectDaemon(cfg, connectChan)
+	if err != nil {
+		return err
+	}
+	defer dcrdClient.Disconnect()
+
+	// Refuse to start if dcrd is incompatible.
+	var errs []error
+	err = checkAPIVersion(dcrdClient, "dcrd", "dcrdjsonrpcapi")
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkNetwork(dcrdClient, "dcrd"); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, checkMethods(dcrdClient, "dcrd",
+		watchOnlyDcrdMethods)...)
+	if len(errs) != 0 {
+		fmt.Printf("Incompatible RPC server:\n")
+		for _, err := range errs {
+			fmt.Printf("  %s\n", err.Error())
+		}
+		return fmt.Errorf("dcrd can not be used in watch-only mode")
+	}
+
+	watcher := newTicketWatcher(dcrdClient, cfg.WatchAddresses)
+	height, err := dcrdClient.GetBlockCount()
+	if err != nil {
+		return err
+	}
+	from := watchRescanStart(cfg.WatchFromHeight, height)
+	if err := watcher.rescan(from, height); err != nil {
+		return err
+	}
+	if err := watcher.scanMempool(); err != nil {
+		return err
+	}
+	watcher.report(int32(height))
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	if cfg.PIDFile != "" {
+		if err := writePIDFile(cfg.PIDFile); err != nil {
+			return fmt.Errorf("Failed to write PID file: %v", err)
+		}
+		defer removePIDFile(cfg.PIDFile)
+	}
+	log.Infof("Watching %v ticket %s", len(cfg.WatchAddresses),
+		pickNoun(len(cfg.WatchAddresses), "address", "addresses"))
+	sdNotify("READY=1")
+	for {
+		select {
+		case height := <-connectChan:
+			daemonLog.Infof("Block height %v connected", height)
+			if err := watcher.blockConnected(height); err != nil {
+				log.Errorf("Failed to update watched tickets: %v", err)
+			}
+		case sig := <-interrupt:
+			log.Infof("Received signal (%s), shutting down", sig)
+			sdNotify("STOPPING=1")
+			return nil
+		}
+	}
+}
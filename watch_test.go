@@ -0,0 +1,227 @@
+// Copyright (c) 2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cjepson/dcrticketbuyer/rpctest"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/txscript"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrutil"
+)
+
+// newTestTicket returns a ticket of 10 coins giving its voting rights to
+// addr. Tickets with different n have different hashes.
+func newTestTicket(t *testing.T, addr dcrutil.Address, n uint32) *wire.MsgTx {
+	pkScript, err := txscript.PayToSStx(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtx := wire.NewMsgTx()
+	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, n,
+		wire.TxTreeRegular), nil))
+	mtx.AddTxOut(wire.NewTxOut(10e8, pkScript))
+	return mtx
+}
+
+// newTestSpend returns a vote, or a revocation if revoke is true, spending
+// ticket and paying to addr.
+func newTestSpend(t *testing.T, ticket *wire.MsgTx, addr dcrutil.Address,
+	revoke bool) *wire.MsgTx {
+	var pkScript []byte
+	var err error
+	if revoke {
+		pkScript, err = txscript.PayToSSRtx(addr)
+	} else {
+		pkScript, err = txscript.PayToSSGen(addr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := ticket.TxSha()
+	mtx := wire.NewMsgTx()
+	mtx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&hash, 0, wire.TxTreeStake),
+		nil))
+	mtx.AddTxOut(wire.NewTxOut(10e8, pkScript))
+	return mtx
+}
+
+// newTestWatcher connects a ticket watcher for addresses to the fake daemon
+// of a test harness. The returned function disconnects it.
+func newTestWatcher(t *testing.T, h *rpctest.Harness,
+	addresses []string) (*ticketWatcher, func()) {
+	connectChan := make(chan int32)
+	dcrdClient, err := connectDaemon(newTestConfig(h), connectChan)
+	if err != nil {
+		t.Fatalf("failed to connect to the fake dcrd: %v", err)
+	}
+
+	// Drain the notifications so that they never block the client.
+	quit := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-connectChan:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	return newTicketWatcher(dcrdClient, addresses), func() {
+		dcrdClient.Shutdown()
+		close(quit)
+	}
+}
+
+// checkTicketStates fails the test if the tickets tracked by w are not in
+// the wanted states.
+func checkTicketStates(t *testing.T, step string, w *ticketWatcher,
+	want map[chainhash.Hash]ticketState) {
+	got := make(map[chainhash.Hash]ticketState, len(w.tickets))
+	for hash, ticket := range w.tickets {
+		got[hash] = ticket.state
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s: got ticket states %v, want %v", step, got, want)
+	}
+}
+
+// TestTicketWatcher ensures that the tickets paying to the watched
+// addresses are followed through the mempool, immature, live, voted,
+// missed and revoked states by scanMempool, scanBlock and checkMissed,
+// and that a rescan of the default window at startup finds them again.
+func TestTicketWatcher(t *testing.T) {
+	h := newTestHarness(t, 100)
+	defer h.Close()
+	watched := testAddress(t, 1)
+	other := testAddress(t, 2)
+	w, disconnect := newTestWatcher(t, h,
+		[]string{watched.EncodeAddress()})
+	defer disconnect()
+
+	voter := newTestTicket(t, watched, 0)
+	missed := newTestTicket(t, watched, 1)
+	evicted := newTestTicket(t, watched, 2)
+	unwatched := newTestTicket(t, other, 3)
+	voterHash, missedHash := voter.TxSha(), missed.TxSha()
+
+	scanBlock := func(height int64) {
+		if err := w.scanBlock(height); err != nil {
+			t.Fatalf("failed to scan block %v: %v", height, err)
+		}
+	}
+	scanMempool := func() {
+		if err := w.scanMempool(); err != nil {
+			t.Fatalf("failed to scan the mempool: %v", err)
+		}
+	}
+
+	for _, tx := range []*wire.MsgTx{voter, missed, evicted, unwatched} {
+		h.Dcrd.AddMempoolTicket(tx)
+	}
+	scanMempool()
+	checkTicketStates(t, "mempool", w, map[chainhash.Hash]ticketState{
+		voterHash:       ticketMempool,
+		missedHash:      ticketMempool,
+		evicted.TxSha(): ticketMempool,
+	})
+
+	h.Dcrd.DropMempool()
+	for _, tx := range []*wire.MsgTx{voter, missed, unwatched} {
+		h.Dcrd.AddMempoolTicket(tx)
+	}
+	scanMempool()
+	checkTicketStates(t, "evicted", w, map[chainhash.Hash]ticketState{
+		voterHash:  ticketMempool,
+		missedHash: ticketMempool,
+	})
+
+	scanBlock(h.ConnectBlock())
+	scanMempool()
+	checkTicketStates(t, "mined", w, map[chainhash.Hash]ticketState{
+		voterHash:  ticketImmature,
+		missedHash: ticketImmature,
+	})
+
+	var height int64
+	for i := uint16(1); i < activeNet.TicketMaturity; i++ {
+		height = h.ConnectBlock()
+	}
+	scanBlock(height)
+	checkTicketStates(t, "before maturity", w, map[chainhash.Hash]ticketState{
+		voterHash:  ticketImmature,
+		missedHash: ticketImmature,
+	})
+
+	scanBlock(h.ConnectBlock())
+	checkTicketStates(t, "mature", w, map[chainhash.Hash]ticketState{
+		voterHash:  ticketLive,
+		missedHash: ticketLive,
+	})
+
+	h.Dcrd.QueueStakeTx(newTestSpend(t, voter, watched, false))
+	scanBlock(h.ConnectBlock())
+	checkTicketStates(t, "voted", w, map[chainhash.Hash]ticketState{
+		voterHash:  ticketVoted,
+		missedHash: ticketLive,
+	})
+
+	h.Dcrd.SetMissedTickets([]string{missedHash.String(),
+		evicted.TxSha().String()})
+	if err := w.checkMissed(); err != nil {
+		t.Fatalf("failed to check missed tickets: %v", err)
+	}
+	checkTicketStates(t, "missed", w, map[chainhash.Hash]ticketState{
+		voterHash:  ticketVoted,
+		missedHash: ticketMissed,
+	})
+
+	h.Dcrd.QueueStakeTx(newTestSpend(t, missed, watched, true))
+	height = h.ConnectBlock()
+	scanBlock(height)
+	want := map[chainhash.Hash]ticketState{
+		voterHash:  ticketVoted,
+		missedHash: ticketRevoked,
+	}
+	checkTicketStates(t, "revoked", w, want)
+
+	// A watcher started now finds the same tickets in the blocks of the
+	// default rescan window.
+	rw, rdisconnect := newTestWatcher(t, h,
+		[]string{watched.EncodeAddress()})
+	defer rdisconnect()
+	if err := rw.rescan(watchRescanStart(0, height), height); err != nil {
+		t.Fatalf("failed to rescan: %v", err)
+	}
+	checkTicketStates(t, "rescan", rw, want)
+}
+
+// TestWatchRescanStart ensures the rescan at startup covers the blocks of
+// the ticket maturity and expiry window unless a height is given.
+func TestWatchRescanStart(t *testing.T) {
+	window := int64(activeNet.TicketMaturity) + int64(activeNet.TicketExpiry)
+	tests := []struct {
+		name       string
+		fromHeight int64
+		height     int64
+		want       int64
+	}{
+		{"short chain", 0, 100, 0},
+		{"window", 0, window + 100, 100},
+		{"given height", 50, window + 100, 50},
+	}
+
+	for _, test := range tests {
+		got := watchRescanStart(test.fromHeight, test.height)
+		if got != test.want {
+			t.Errorf("%s: got rescan start %v, want %v", test.name, got,
+				test.want)
+		}
+	}
+}